@@ -2,12 +2,15 @@ package backend
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mijara/statspout/log"
@@ -21,52 +24,168 @@ const (
 
 // Client holding data for the Backend.
 type Client struct {
-	service *Service       // the service to handle multiple daemons as a pipeline.
-	daemons int            // the number of daemons.
-	repo    repo.Interface // the repository to push stats.
-	exit    bool           // did this client exited.
+	service *Service           // the service to handle multiple daemons as a pipeline.
+	daemons int                // the number of daemons.
+	repo    repo.Interface     // the repository to push stats.
+	ctx     context.Context    // context of this client, done once it's closing.
+	cancel  context.CancelFunc // cancels the context of this client.
 
 	clients   chan *httputil.ClientConn // queue of clients for daemons.
 	dedicated *httputil.ClientConn      // dedicated client for side requests.
+	connLock  sync.Mutex                // guards dedicated, which is replaced on reconnection.
+
+	connected bool       // whether the connections to the Docker host are working.
+	stateLock sync.Mutex // guards connected.
+
+	registry *Registry // tracked containers, shared by the poller and the events monitor.
+
+	// held to push stats, and exclusively to clear a container no longer tracked, so the stats of a
+	// container checked as tracked are queued before its clear, never after it.
+	clearLock sync.RWMutex
 
 	events *EventsMonitor // monitor attached to the events API.
+
+	host     string   // identifier of the Docker host, attached to every stats.
+	endpoint Endpoint // endpoint of the Docker API.
+
+	options Options // how stats are collected.
+
+	streaming   bool               // whether stats are collected from long-lived streams.
+	streams     map[string]*Stream // open stats streams, by canonical name.
+	streamsLock sync.Mutex         // guards streams.
+
+	samples     map[string]*stats.Stats // previous stats of each container, to calculate rates.
+	samplesLock sync.Mutex              // guards samples.
+}
+
+// Options of the Backend Client.
+type Options struct {
+	Daemons       int           // number of daemons available to take requests.
+	Interval      time.Duration // time between queries, frames of a stream older than it are not emitted.
+	Stream        bool          // whether stats are collected from long-lived streams instead of one request per query.
+	BlkioDevices  bool          // whether the block I/O stats include the per-device breakdown.
+	CpuNormalized bool          // whether CPU percentages are of the whole host instead of per core.
+	TopCount      int           // number of processes kept from each process listing.
 }
 
 // Work to process by daemons.
 type Workload struct {
 	connection *httputil.ClientConn // connection on which the request is going to be made.
-	container  Container           // container object to request.
+	container  Container            // container object to request.
+	kind       int                  // what is requested, one of the WORKLOAD_* kinds.
+	tick       *tick                // query the stats belong to, only for WORKLOAD_STATS.
+}
+
+// Stats collected on a single query of many containers, pushed at once when every container is done.
+type tick struct {
+	lock    sync.Mutex
+	pending int // containers not done yet.
+	stats   []*stats.Stats
 }
 
+// Adds the stats of a container, nil if there are none. Returns the stats collected once every
+// container is done, nil before.
+func (t *tick) done(s *stats.Stats) []*stats.Stats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if s != nil {
+		t.stats = append(t.stats, s)
+	}
+
+	t.pending--
+	if t.pending > 0 {
+		return nil
+	}
+
+	return t.stats
+}
+
+// Kinds of workloads.
+const (
+	WORKLOAD_STATS   = iota // stats of the container, pushed to the repository.
+	WORKLOAD_TOP            // top processes of the container, pushed to the repository.
+	WORKLOAD_INSPECT        // inspect data of the container, kept in the registry.
+)
+
 // Cpu Usage reported by the Docker Stats API.
 type CpuUsage struct {
-	Total  uint64   `json:"total_usage"`
-	PerCpu []uint64 `json:"percpu_usage"`
+	Total             uint64   `json:"total_usage"`
+	PerCpu            []uint64 `json:"percpu_usage"`
+	UsageInUsermode   uint64   `json:"usage_in_usermode"`
+	UsageInKernelmode uint64   `json:"usage_in_kernelmode"`
+}
+
+// CFS throttling reported by the Docker Stats API, only when the container has a CPU limit.
+type ThrottlingData struct {
+	Periods          uint64 `json:"periods"`
+	ThrottledPeriods uint64 `json:"throttled_periods"`
+	ThrottledTime    uint64 `json:"throttled_time"` // in nanoseconds.
 }
 
 // Cpu Stats reported by the Docker Stats API.
 type CpuStats struct {
-	Usage          CpuUsage `json:"cpu_usage"`
-	SystemCpuUsage uint64   `json:"system_cpu_usage"`
+	Usage          CpuUsage       `json:"cpu_usage"`
+	SystemCpuUsage uint64         `json:"system_cpu_usage"`
+	OnlineCpus     uint32         `json:"online_cpus"`
+	Throttling     ThrottlingData `json:"throttling_data"`
 }
 
 // Memory Stats reported by the Docker Stats API.
 type MemoryStats struct {
-	Usage uint64 `json:"usage"`
-	Limit uint64 `json:"limit"`
+	Usage uint64       `json:"usage"`
+	Limit uint64       `json:"limit"`
+	Stats MemoryDetail `json:"stats"`
+}
+
+// Memory breakdown of the Docker Stats API, fields are named after the cgroup v1 memory.stat file,
+// except those only present on cgroup v2.
+type MemoryDetail struct {
+	Cache             uint64 `json:"cache"`
+	Rss               uint64 `json:"rss"`
+	Swap              uint64 `json:"swap"`
+	MappedFile        uint64 `json:"mapped_file"`
+	TotalInactiveFile uint64 `json:"total_inactive_file"`
+	PgFault           uint64 `json:"pgfault"`
+	PgMajFault        uint64 `json:"pgmajfault"`
+
+	// cgroup v2.
+	Anon         uint64 `json:"anon"`
+	File         uint64 `json:"file"`
+	InactiveFile uint64 `json:"inactive_file"`
 }
 
 // Network Interface stats.
 type InterfaceStats struct {
-	RxBytes   uint32 `json:"rx_bytes"`
-	RxDropped uint32 `json:"rx_dropped"`
-	RxErrors  uint32 `json:"rx_errors"`
-	RxPackets uint32 `json:"rx_packets"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxDropped uint64 `json:"rx_dropped"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxPackets uint64 `json:"rx_packets"`
+
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxDropped uint64 `json:"tx_dropped"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxPackets uint64 `json:"tx_packets"`
+}
 
-	TxBytes   uint32 `json:"tx_bytes"`
-	TxDropped uint32 `json:"tx_dropped"`
-	TxErrors  uint32 `json:"tx_errors"`
-	TxPackets uint32 `json:"tx_packets"`
+// Pids Stats reported by the Docker Stats API.
+type PidsStats struct {
+	Current uint64 `json:"current"`
+	Limit   uint64 `json:"limit"` // max uint64 on cgroup v2 when unlimited.
+}
+
+// Block I/O entry reported by the Docker Stats API, for a single device and operation.
+type BlkioEntry struct {
+	Major uint64 `json:"major"`
+	Minor uint64 `json:"minor"`
+	Op    string `json:"op"`
+	Value uint64 `json:"value"`
+}
+
+// Block I/O Stats reported by the Docker Stats API.
+type BlkioStats struct {
+	IoServiceBytesRecursive []BlkioEntry `json:"io_service_bytes_recursive"`
+	IoServicedRecursive     []BlkioEntry `json:"io_serviced_recursive"`
 }
 
 // Container Stats reported by the Docker Stats API.
@@ -78,44 +197,140 @@ type ContainerStats struct {
 
 	Networks map[string]InterfaceStats `json:"networks"`
 
+	Blkio BlkioStats `json:"blkio_stats"`
+
+	Pids PidsStats `json:"pids_stats"`
+
 	Read time.Time `json:"read"`
 }
 
 // Container struct to unmarshal JSON response form Docker List Containers API.
 type Container struct {
-	Names  []string          `json:"Names"`
-	Labels map[string]string `json:"Labels"`
+	ID      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	ImageID string            `json:"ImageID"`
+	Command string            `json:"Command"`
+	Created int64             `json:"Created"` // unix time.
+	Labels  map[string]string `json:"Labels"`
+	State   string            `json:"State"`
 
 	CanonicalName string
+	Paused        bool   // whether the container is paused.
+	Health        string // last health status reported, empty if the container has no health check.
+
+	// inspect data, refreshed periodically.
+	RestartCount int       // number of restarts done by the restart policy.
+	OOMKilled    bool      // whether the last exit was because of running out of memory.
+	StartedAt    time.Time // last time the container started, zero if unknown.
+	CpuLimit     float64   // configured CPU limit, in cores, zero if unlimited.
+	MemoryLimit  uint64    // configured memory limit, in bytes, zero if unlimited.
 }
 
 type ContainerInspect struct {
-	Name string `json:"Name"`
+	ID      string    `json:"Id"`
+	Name    string    `json:"Name"`
+	Image   string    `json:"Image"` // image ID.
+	Path    string    `json:"Path"`
+	Args    []string  `json:"Args"`
+	Created time.Time `json:"Created"`
+
+	RestartCount int `json:"RestartCount"`
 
 	Config struct {
+		Image  string            `json:"Image"`
 		Labels map[string]string `json:"Labels"`
 	} `json:"Config"`
+
+	State struct {
+		Paused    bool      `json:"Paused"`
+		OOMKilled bool      `json:"OOMKilled"`
+		StartedAt time.Time `json:"StartedAt"`
+		Health    struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+
+	HostConfig struct {
+		NanoCpus  int64 `json:"NanoCpus"`
+		CpuQuota  int64 `json:"CpuQuota"`
+		CpuPeriod int64 `json:"CpuPeriod"`
+		Memory    int64 `json:"Memory"`
+	} `json:"HostConfig"`
+}
+
+// Copies the inspect data that changes over time into the container.
+func (ci *ContainerInspect) apply(container *Container) {
+	container.Paused = ci.State.Paused
+	container.Health = ci.State.Health.Status
+	container.RestartCount = ci.RestartCount
+	container.OOMKilled = ci.State.OOMKilled
+	container.StartedAt = ci.State.StartedAt
+	container.CpuLimit = calcCpuLimit(ci)
+	container.MemoryLimit = uint64(ci.HostConfig.Memory)
 }
 
-// Creates a new Backend Client, which uses the given repository, can be created as a HTTP or Socket
-// client, specified by the http parameter. The address parameter must point to the endpoint or socket path,
-// finally, n will be the number of daemons available to take requests.
-func New(repo repo.Interface, http bool, address string, n int) (*Client, error) {
+// Copies the inspect data of the tracked container, which the containers list doesn't have.
+func (container *Container) keepInspect(tracked *Container) {
+	container.Health = tracked.Health
+	container.RestartCount = tracked.RestartCount
+	container.OOMKilled = tracked.OOMKilled
+	container.StartedAt = tracked.StartedAt
+	container.CpuLimit = tracked.CpuLimit
+	container.MemoryLimit = tracked.MemoryLimit
+}
+
+// Creates a new Backend Client, which uses the given repository, can be created as a Socket, HTTP or TLS
+// client, specified by the endpoint parameter. The host parameter identifies the Docker host in the stats,
+// finally, options tell how stats are collected. The client stops working once the given context is done,
+// but Close must be called anyway to release the connections.
+func New(ctx context.Context, repo repo.Interface, host string, endpoint Endpoint, options Options) (*Client, error) {
+	n := options.Daemons
+
+	ctx, cancel := context.WithCancel(ctx)
+
 	// create a client with simple information.
 	cli := &Client{
-		repo:    repo,
-		daemons: n,
+		ctx:       ctx,
+		cancel:    cancel,
+		repo:      repo,
+		daemons:   n,
+		connected: true,
+		host:      host,
+		endpoint:  endpoint,
+		options:   options,
+		streaming: options.Stream,
+		streams:   make(map[string]*Stream),
+		samples:   make(map[string]*stats.Stats),
 	}
 
+	// create the registry, tracking changes are reflected on the streams and the repository.
+	cli.registry = NewRegistry()
+	cli.registry.Subscribe(Subscriber{
+		OnAdd: cli.startStream,
+		OnRemove: func(container Container) {
+			cli.stopStream(container.CanonicalName)
+			cli.resetRates(container.CanonicalName)
+			cli.clear(container.CanonicalName)
+		},
+		OnRename: func(oldName string, container Container) {
+			cli.stopStream(oldName)
+			cli.resetRates(oldName)
+			cli.resetRates(container.CanonicalName)
+			cli.clear(oldName)
+			cli.startStream(container)
+		},
+	})
+
 	// create the service to hold daemons.
-	cli.service = NewService(n, cli.process, cli.onError)
+	cli.service = NewService(ctx, n, cli.process, cli.onError)
 
 	// create the channel for client connections.
 	cli.clients = make(chan *httputil.ClientConn, n)
 
 	// for each daemon, create one client connection for them to work with.
 	for i := 0; i < n; i++ {
-		conn, err := createConn(http, address)
+		conn, err := createConn(endpoint)
 		if err != nil {
 			return nil, err
 		}
@@ -126,35 +341,68 @@ func New(repo repo.Interface, http bool, address string, n int) (*Client, error)
 	log.Info.Printf("%d daemons clients created.", n)
 
 	// create a dedicated client connection for side requests.
-	conn, err := createConn(http, address)
+	conn, err := createConn(endpoint)
 	if err != nil {
 		return nil, err
 	}
 	cli.dedicated = httputil.NewClientConn(conn, nil)
 
-	cli.events, err = NewEventsMonitor(http, address)
+	cli.events, err = NewEventsMonitor(endpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Info.Printf("Docker client created.")
+	log.Info.Printf("Docker client created for %s.", host)
 
 	return cli, nil
 }
 
-// Queries the Docker Stats API for a container given by the canonical name.
-func (cli *Client) Query(container Container) {
-	// take one client connection, will block until there's one available.
-	conn := <-cli.clients
+// Queries the Docker Stats API for the given containers, pushing their stats at once when all of them are done.
+func (cli *Client) Query(containers []Container) {
+	// client is closing, ignore the query.
+	if cli.ctx.Err() != nil {
+		return
+	}
 
-	// send the workload to the service, which will then select one daemon for the task.
-	cli.service.Send(Workload{
-		connection: conn,
-		container:  container,
-	})
+	// the host is not reachable, there's no point on querying until reconnected.
+	if !cli.Connected() {
+		return
+	}
+
+	// on stream mode, just emit the last frame received from each container stream.
+	if cli.streaming {
+		var batch []*stats.Stats
+		for _, container := range containers {
+			if s := cli.emit(container); s != nil {
+				batch = append(batch, s)
+			}
+		}
 
-	// send back the client connection (this will never block).
-	cli.clients <- conn
+		cli.push(batch)
+		return
+	}
+
+	// the last container done pushes the stats of all of them.
+	t := &tick{pending: len(containers)}
+
+	for _, container := range containers {
+		// take one client connection, will block until there's one available.
+		conn := <-cli.clients
+
+		// send the workload to the service, which will then select one daemon for the task.
+		sent := cli.service.Send(Workload{
+			connection: conn,
+			container:  container,
+			tick:       t,
+		})
+
+		// send back the client connection (this will never block).
+		cli.clients <- conn
+
+		if !sent {
+			cli.push(t.done(nil))
+		}
+	}
 }
 
 // Get containers names currently available in the Docker instance (only the ones that are running).
@@ -164,8 +412,9 @@ func (cli *Client) GetContainers() (map[string]Container, error) {
 		return nil, err
 	}
 
-	res, err := cli.dedicated.Do(req)
+	res, err := cli.side().Do(req)
 	if err != nil {
+		cli.disconnected(err)
 		return nil, err
 	}
 	defer res.Body.Close()
@@ -182,58 +431,122 @@ func (cli *Client) GetContainers() (map[string]Container, error) {
 
 	for _, container := range containers {
 		container.CanonicalName = container.Names[0][1:]
+		container.Paused = container.State == "paused"
 		result[container.CanonicalName] = container
 	}
 
 	return result, nil
 }
 
-func (cli *Client) StartMonitor(containers map[string]Container) {
-	cli.events.monitor(cli, containers)
+// Syncs the registry with the containers currently running in the Docker instance.
+func (cli *Client) Sync() error {
+	containers, err := cli.GetContainers()
+	if err != nil {
+		return err
+	}
+
+	cli.registry.Sync(containers)
+	return nil
+}
+
+// Starts monitoring Docker events to keep the registry up to date, until the client is closed.
+func (cli *Client) StartMonitor() {
+	cli.events.monitor(cli.ctx, cli)
 }
 
-// Closes all connections and Goroutines.
-func (cli *Client) Close() {
-	cli.exit = true
+// Registry of the containers tracked in this Docker host.
+func (cli *Client) Registry() *Registry {
+	return cli.registry
+}
+
+// Identifier of the Docker host of this client.
+func (cli *Client) Host() string {
+	return cli.host
+}
 
-	cli.events.Close()
-	cli.service.Close()
+// Closes all connections and Goroutines, waiting for the queries in progress to finish until the given
+// context is done. If that happens, the context error is returned and the connections are closed anyway.
+func (cli *Client) Close(ctx context.Context) error {
+	cli.cancel()
 
+	eventsErr := cli.events.Close(ctx)
+	serviceErr := cli.service.Close(ctx)
+
+	cli.stopStreams()
+
+	// the connections may be taken by a reconnection, don't wait for them after the deadline.
 	for i := 0; i < cli.daemons; i++ {
-		conn := <-cli.clients
-		conn.Close()
+		select {
+		case conn := <-cli.clients:
+			conn.Close()
+		case <-ctx.Done():
+			i = cli.daemons
+		}
 	}
 
-	cli.dedicated.Close()
+	cli.side().Close()
+
+	if eventsErr != nil {
+		return eventsErr
+	}
+
+	return serviceErr
+}
+
+// Returns the dedicated client connection for side requests.
+func (cli *Client) side() *httputil.ClientConn {
+	cli.connLock.Lock()
+	defer cli.connLock.Unlock()
+
+	return cli.dedicated
 }
 
 // Process a single requests, this will be spawned by the some daemon and it meant to be used
 // as a callback routine.
 func (cli *Client) process(v interface{}) error {
-	// client wants to exit, ignore workload.
-	if cli.exit {
-		return nil
-	}
-
 	// assert the type of the workload.
 	wl, ok := v.(Workload)
 	if !ok {
 		return errors.New(fmt.Sprintf("This is not a workload %T", v))
 	}
 
+	switch wl.kind {
+	case WORKLOAD_TOP:
+		return cli.processTop(wl)
+	case WORKLOAD_INSPECT:
+		return cli.processInspect(wl)
+	}
+
+	// the container is done once its stats are read, or the request failed.
+	var s *stats.Stats
+	defer func() {
+		cli.push(wl.tick.done(s))
+	}()
+
 	// create the request for stats.
 	req, err := http.NewRequest("GET", fmt.Sprintf(STATS_QUERY, wl.container.CanonicalName), nil)
 	if err != nil {
 		return err
 	}
 
-	// request using the client.
+	// request using the client, failing here means the connection is broken.
 	res, err := wl.connection.Do(req)
 	if err != nil {
+		cli.disconnected(err)
 		return err
 	}
 	defer res.Body.Close()
 
+	// the container is gone, the events monitor stops tracking it.
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	// an error would decode into zero stats, breaking the rates of the next ones.
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Stats request failed for " + wl.container.CanonicalName + ": " + res.Status)
+	}
+
 	// here, since the stats API is a stream, we have to read until the delimiter, and then break with EOF.
 	reader := bufio.NewReader(res.Body)
 	for {
@@ -249,22 +562,204 @@ func (cli *Client) process(v interface{}) error {
 			return err
 		}
 
-		// push the stats to the repository, calculating relevant data.
-		cli.repo.Push(&stats.Stats{
-			MemoryPercent: calcMemoryPercent(container),
-			CpuPercent:    calcCpuPercent(container),
-			MemoryUsage:   container.Memory.Usage,
-			TxBytesTotal:  sumTxBytesTotal(container.Networks),
-			RxBytesTotal:  sumRxBytesTotal(container.Networks),
-			Timestamp:     container.Read,
-			Name:          wl.container.CanonicalName,
-			Labels:        wl.container.Labels,
-		})
+		// calculate relevant data, pushed along the stats of the other containers.
+		s = cli.newStats(wl.container, container)
 	}
 
 	return nil
 }
 
+// Stats of the last frame received from the stream of the given container, opening the stream
+// if there's none yet. Returns nil if there's no frame to push.
+func (cli *Client) emit(container Container) *stats.Stats {
+	// the container could be removed since the snapshot was taken.
+	if _, ok := cli.registry.Get(container.CanonicalName); !ok {
+		return nil
+	}
+
+	cli.streamsLock.Lock()
+	s, ok := cli.streams[container.CanonicalName]
+	cli.streamsLock.Unlock()
+
+	if !ok {
+		cli.startStream(container)
+		return nil
+	}
+
+	// the stream ended (the connection broke or the container is gone), open it again once its backoff
+	// is over.
+	if !s.Alive() {
+		if backoff, ok := s.Retry(); ok {
+			cli.stopStream(container.CanonicalName)
+			cli.openStream(container, backoff)
+		}
+		return nil
+	}
+
+	frame, received := s.Last()
+	if frame == nil {
+		// nothing received yet.
+		return nil
+	}
+
+	// a stalled stream would emit the same frame over and over.
+	if cli.options.Interval > 0 && time.Since(received) > cli.options.Interval {
+		return nil
+	}
+
+	return cli.newStats(container, frame)
+}
+
+// Pushes the stats of a query to the repository, at once if it's capable of it.
+func (cli *Client) push(batch []*stats.Stats) {
+	cli.clearLock.RLock()
+	defer cli.clearLock.RUnlock()
+
+	// containers removed while querying them, pushing them would bring back their cleared data.
+	tracked := batch[:0]
+	for _, s := range batch {
+		if _, ok := cli.registry.Get(s.Name); ok {
+			tracked = append(tracked, s)
+		}
+	}
+
+	if len(tracked) == 0 {
+		return
+	}
+
+	if err := repo.PushBatch(cli.repo, tracked); err != nil {
+		cli.onError(err)
+	}
+}
+
+// Clears the data of a container no longer tracked from the repository, once the pushes in progress
+// are done.
+func (cli *Client) clear(name string) {
+	cli.clearLock.Lock()
+	defer cli.clearLock.Unlock()
+
+	cli.repo.Clear(cli.host, name)
+}
+
+// Opens a stats stream for the given container, only on stream mode.
+func (cli *Client) startStream(container Container) {
+	cli.openStream(container, RECONNECT_MIN_BACKOFF)
+}
+
+// Opens a stats stream for the given container, only on stream mode, waiting the given backoff before
+// opening it again once it ends.
+func (cli *Client) openStream(container Container, backoff time.Duration) {
+	if !cli.streaming {
+		return
+	}
+
+	cli.streamsLock.Lock()
+	defer cli.streamsLock.Unlock()
+
+	if _, ok := cli.streams[container.CanonicalName]; ok {
+		return
+	}
+
+	// a container no longer tracked would never have its stream closed. Checked under the streams lock,
+	// since it's removed from the registry before its stream is closed.
+	if _, ok := cli.registry.Get(container.CanonicalName); !ok {
+		return
+	}
+
+	s, err := NewStream(cli.endpoint, container, backoff)
+	if err != nil {
+		cli.onError(err)
+		return
+	}
+
+	cli.streams[container.CanonicalName] = s
+}
+
+// Closes the stats stream of the named container, if any.
+func (cli *Client) stopStream(name string) {
+	cli.streamsLock.Lock()
+	defer cli.streamsLock.Unlock()
+
+	if s, ok := cli.streams[name]; ok {
+		s.Close()
+		delete(cli.streams, name)
+	}
+}
+
+// Closes every open stats stream.
+func (cli *Client) stopStreams() {
+	cli.streamsLock.Lock()
+	defer cli.streamsLock.Unlock()
+
+	for name, s := range cli.streams {
+		s.Close()
+		delete(cli.streams, name)
+	}
+}
+
+// Builds the project stats from the Docker stats of the given container, calculating relevant data.
+func (cli *Client) newStats(container Container, cs *ContainerStats) *stats.Stats {
+	s := &stats.Stats{
+		MemoryPercent:         calcMemoryPercent(cs),
+		CpuPercent:            calcCpuPercent(cs, cli.options.CpuNormalized),
+		CpuUserPercent:        calcCpuUserPercent(cs, cli.options.CpuNormalized),
+		CpuSystemPercent:      calcCpuSystemPercent(cs, cli.options.CpuNormalized),
+		CpuThrottledRatio:     calcCpuThrottledRatio(cs),
+		CpuThrottledTime:      cs.Cpu.Throttling.ThrottledTime,
+		MemoryUsage:           cs.Memory.Usage,
+		MemoryLimit:           cs.Memory.Limit,
+		MemoryRss:             calcMemoryRss(&cs.Memory),
+		MemoryCache:           calcMemoryCache(&cs.Memory),
+		MemorySwap:            cs.Memory.Stats.Swap,
+		MemoryWorkingSet:      calcMemoryWorkingSet(&cs.Memory),
+		MemoryPgFaults:        cs.Memory.Stats.PgFault,
+		MemoryPgMajFaults:     cs.Memory.Stats.PgMajFault,
+		Pids:                  cs.Pids.Current,
+		PidsLimit:             calcPidsLimit(&cs.Pids),
+		Health:                container.Health,
+		RestartCount:          container.RestartCount,
+		OOMKilled:             container.OOMKilled,
+		CpuLimit:              container.CpuLimit,
+		MemoryLimitConfigured: container.MemoryLimit,
+		TxBytesTotal:          sumTxBytesTotal(cs.Networks),
+		RxBytesTotal:          sumRxBytesTotal(cs.Networks),
+		Timestamp:             cs.Read,
+		Name:                  container.CanonicalName,
+		Host:                  cli.host,
+		Paused:                container.Paused,
+		Labels:                container.Labels,
+		Identity:              container.identity(),
+		Networks:              networkInterfaces(cs.Networks),
+	}
+
+	s.BlockReadBytes, s.BlockWriteBytes = sumBlkio(cs.Blkio.IoServiceBytesRecursive)
+	s.BlockReadOps, s.BlockWriteOps = sumBlkio(cs.Blkio.IoServicedRecursive)
+
+	if cli.options.BlkioDevices {
+		s.BlockDevices = blkioDevices(&cs.Blkio)
+	}
+
+	if !container.StartedAt.IsZero() && s.Timestamp.After(container.StartedAt) {
+		s.Uptime = s.Timestamp.Sub(container.StartedAt).Seconds()
+	}
+
+	cli.calcRates(s)
+
+	return s
+}
+
+// Pushes a container event to the repository, only if it's capable of storing events.
+func (cli *Client) pushEvent(event *stats.Event) {
+	r, ok := cli.repo.(repo.EventInterface)
+	if !ok {
+		return
+	}
+
+	if err := r.PushEvent(event); err != nil {
+		cli.onError(err)
+	}
+}
+
 // Reports errors to STDERR.
 func (cli *Client) onError(err error) {
 	log.Error.Printf(err.Error())
@@ -272,23 +767,39 @@ func (cli *Client) onError(err error) {
 
 // RequestContainer ask the docker API for a single container data.
 func (cli *Client) RequestContainer(name string) (*Container, error) {
-	req, err := http.NewRequest("GET", "/containers/"+name+"/json", nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf(INSPECT_QUERY, name), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := cli.dedicated.Do(req)
+	res, err := cli.side().Do(req)
 	if err != nil {
+		cli.disconnected(err)
 		return nil, err
 	}
 	defer res.Body.Close()
 
+	// a container removed since, would decode into an empty one.
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Inspect request failed for " + name + ": " + res.Status)
+	}
+
 	container := &ContainerInspect{}
-	json.NewDecoder(res.Body).Decode(container)
+	if err := json.NewDecoder(res.Body).Decode(container); err != nil {
+		return nil, err
+	}
 
-	return &Container{
+	c := &Container{
+		ID:            container.ID,
 		Names:         []string{container.Name},
+		Image:         container.Config.Image,
+		ImageID:       container.Image,
+		Command:       strings.TrimSpace(container.Path + " " + strings.Join(container.Args, " ")),
+		Created:       container.Created.Unix(),
 		CanonicalName: name,
 		Labels:        container.Config.Labels,
-	}, nil
+	}
+	container.apply(c)
+
+	return c, nil
 }
@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+)
+
+// Endpoint of the Docker API.
+type Endpoint struct {
+	Network string      // network to dial: unix, tcp or npipe.
+	Address string      // socket path, TCP address or named pipe of the Docker API.
+	TLS     *tls.Config // TLS configuration, nil for plain connections.
+}
+
+// Creates an endpoint for a Unix socket with the given path.
+func NewSocketEndpoint(path string) Endpoint {
+	return Endpoint{Network: "unix", Address: path}
+}
+
+// Creates an endpoint for a plain TCP (http) address.
+func NewHTTPEndpoint(address string) Endpoint {
+	return Endpoint{Network: "tcp", Address: address}
+}
+
+// Creates an endpoint for a TCP address secured with TLS.
+func NewTLSEndpoint(address string, config *tls.Config) Endpoint {
+	return Endpoint{Network: "tcp", Address: address, TLS: config}
+}
+
+// Creates an endpoint for a Windows named pipe with the given path.
+func NewNamedPipeEndpoint(path string) Endpoint {
+	return Endpoint{Network: "npipe", Address: path}
+}
+
+// Creates a TLS configuration using the given CA, certificate and key paths. The CA is only used
+// if verify is true, otherwise the server certificate is not checked (like docker without --tlsverify).
+// Certificate and key may be empty, in which case no client certificate is sent.
+func NewTLSConfig(ca, cert, key string, verify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: !verify,
+	}
+
+	if verify && ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("Could not parse CA certificate: " + ca)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return config, nil
+}
+
+// Creates a connection to the given endpoint.
+func createConn(endpoint Endpoint) (net.Conn, error) {
+	if endpoint.Network == "npipe" {
+		return dialPipe(endpoint.Address)
+	}
+
+	if endpoint.TLS != nil {
+		return tls.Dial(endpoint.Network, endpoint.Address, endpoint.TLS)
+	}
+
+	return net.Dial(endpoint.Network, endpoint.Address)
+}
@@ -2,12 +2,26 @@ package backend
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/stats"
+)
+
+const (
+	EVENTS_QUERY       = "/events"
+	EVENTS_SINCE_QUERY = "/events?since=%d.%09d"
+	EVENTS_UNTIL_QUERY = "&until=%d.%09d"
 )
 
 type Event struct {
@@ -15,103 +29,373 @@ type Event struct {
 	Action string `json:"Action"`
 	Actor  struct {
 		Attributes struct {
-			Name    string `json:"name"`
-			OldName string `json:"oldName,omitempty"`
+			Name     string `json:"name"`
+			OldName  string `json:"oldName,omitempty"`
+			ExitCode string `json:"exitCode,omitempty"`
 		} `json:"Attributes"`
 	} `json:"Actor"`
+
+	TimeNano int64 `json:"timeNano"`
 }
 
 type EventsMonitor struct {
-	client *httputil.ClientConn
-	quit   chan bool
+	endpoint Endpoint // endpoint of the Docker API, to reconnect.
+
+	lock sync.Mutex // guards conn and quit.
+	conn net.Conn   // current connection, closed to stop reading.
+	quit bool       // whether the monitor was closed.
+
+	done chan struct{} // closed once the monitor stops.
+
+	since int64 // time of the last event seen, in nanoseconds.
 }
 
-func NewEventsMonitor(http bool, address string) (*EventsMonitor, error) {
-	conn, err := createConn(http, address)
+func NewEventsMonitor(endpoint Endpoint) (*EventsMonitor, error) {
+	conn, err := createConn(endpoint)
 	if err != nil {
 		return nil, err
 	}
 
 	return &EventsMonitor{
-		client: httputil.NewClientConn(conn, nil),
+		endpoint: endpoint,
+		conn:     conn,
 	}, nil
 }
 
-func (em *EventsMonitor) monitor(cli *Client, containers map[string]Container) {
-	em.quit = make(chan bool, 1)
-	go em.loop(cli, containers)
+// Starts reading events in background, until the context is done or the monitor is closed.
+func (em *EventsMonitor) monitor(ctx context.Context, cli *Client) {
+	em.done = make(chan struct{})
+
+	go func() {
+		<-ctx.Done()
+		em.stop()
+	}()
+
+	go func() {
+		defer close(em.done)
+		em.loop(ctx, cli)
+	}()
+}
+
+// Stops the monitor and waits for it to finish, until the given context is done.
+func (em *EventsMonitor) Close(ctx context.Context) error {
+	em.stop()
+
+	if em.done == nil {
+		return nil
+	}
+
+	select {
+	case <-em.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stops reading events.
+func (em *EventsMonitor) stop() {
+	em.lock.Lock()
+	defer em.lock.Unlock()
+
+	em.quit = true
+
+	// unblocks the reader.
+	if em.conn != nil {
+		em.conn.Close()
+	}
+}
+
+// Whether the monitor was closed.
+func (em *EventsMonitor) closed() bool {
+	em.lock.Lock()
+	defer em.lock.Unlock()
+
+	return em.quit
+}
+
+// Reads events until the monitor is closed, reconnecting with exponential backoff if the stream breaks.
+// Reconnections replay the events missed during the gap, and then reconcile the tracked containers.
+func (em *EventsMonitor) loop(ctx context.Context, cli *Client) {
+	backoff := RECONNECT_MIN_BACKOFF
+	gap := false
+
+	for !em.closed() {
+		if gap {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+
+			if err := em.recover(cli); err != nil {
+				if em.closed() {
+					return
+				}
+
+				backoff *= 2
+				if backoff > RECONNECT_MAX_BACKOFF {
+					backoff = RECONNECT_MAX_BACKOFF
+				}
+
+				log.Error.Printf("Events reconnection failed, retrying in %s: %s", backoff, err.Error())
+				continue
+			}
+
+			log.Info.Printf("Events monitor reconnected to %s.", cli.host)
+
+			gap = false
+			backoff = RECONNECT_MIN_BACKOFF
+		}
+
+		err := em.read(cli, em.query(0))
+
+		if em.closed() {
+			return
+		}
+
+		log.Error.Printf("Events stream of %s broken: %s", cli.host, err.Error())
+		gap = true
+	}
+}
+
+// Replays the events missed during a gap up to now, reconciles the tracked containers and connects again
+// for the events from then on. The reconciliation goes after the replay, otherwise replayed events, like
+// the stop of a container started again, would undo it.
+func (em *EventsMonitor) recover(cli *Client) error {
+	if err := em.dial(); err != nil {
+		return err
+	}
+
+	// without any event seen, there's nothing to replay from.
+	if em.since > 0 {
+		until := time.Now().UnixNano()
+
+		// the stream ends once the events up to until are sent.
+		if err := em.read(cli, em.query(until)); err != io.EOF {
+			return err
+		}
+
+		if until > em.since {
+			em.since = until
+		}
+
+		if err := em.dial(); err != nil {
+			return err
+		}
+	}
+
+	cli.resync()
+
+	return nil
+}
+
+// Query of the events stream, starting from the last event seen and ending on until, if given.
+func (em *EventsMonitor) query(until int64) string {
+	if em.since == 0 {
+		return EVENTS_QUERY
+	}
+
+	// since is inclusive, so it starts right after the last event seen, not to handle it twice.
+	since := em.since + 1
+	query := fmt.Sprintf(EVENTS_SINCE_QUERY, since/int64(time.Second), since%int64(time.Second))
+
+	if until > 0 {
+		query += fmt.Sprintf(EVENTS_UNTIL_QUERY, until/int64(time.Second), until%int64(time.Second))
+	}
+
+	return query
 }
 
-func (em *EventsMonitor) Close() {
-	em.quit <- true
+// Creates a new connection for the events stream.
+func (em *EventsMonitor) dial() error {
+	conn, err := createConn(em.endpoint)
+	if err != nil {
+		return err
+	}
+
+	em.lock.Lock()
+	defer em.lock.Unlock()
+
+	if em.quit {
+		conn.Close()
+		return errors.New("Events monitor closed")
+	}
+
+	em.conn = conn
+	return nil
 }
 
-func (em *EventsMonitor) loop(cli *Client, containers map[string]Container) {
-	req, err := http.NewRequest("GET", "/events", nil)
+// Requests the events stream with the given query on the current connection, and handles events until
+// the stream ends or breaks.
+func (em *EventsMonitor) read(cli *Client, query string) error {
+	em.lock.Lock()
+	conn := em.conn
+	em.lock.Unlock()
+	defer conn.Close()
+
+	req, err := http.NewRequest("GET", query, nil)
 	if err != nil {
-		log.Error.Printf("Could not monitor events: %s", err.Error())
+		return err
 	}
 
-	res, err := em.client.Do(req)
+	res, err := httputil.NewClientConn(conn, nil).Do(req)
 	if err != nil {
-		log.Error.Printf("Events request failed: %s", err.Error())
+		return err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Events request failed: " + res.Status)
+	}
+
 	reader := bufio.NewReader(res.Body)
 
 	for {
-		select {
-		case <-em.quit:
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+
+		event := Event{}
+		err = json.Unmarshal(line, &event)
+		if err != nil {
+			log.Error.Printf("Events response error: %s", err.Error())
+			continue
+		}
+
+		if event.TimeNano > em.since {
+			em.since = event.TimeNano
+		}
+
+		if event.Type == "container" {
+			em.handle(cli, event)
+		}
+	}
+}
+
+// Updates the registry given a container event.
+func (em *EventsMonitor) handle(cli *Client, event Event) {
+	name := event.Actor.Attributes.Name
+
+	// health_status comes with the status itself: "health_status: healthy".
+	action := event.Action
+	status := ""
+	if strings.HasPrefix(action, "health_status: ") {
+		action, status = "health_status", strings.TrimPrefix(action, "health_status: ")
+	}
+
+	switch action {
+	case "start":
+		log.Info.Printf("Container %s started.", name)
+		em.track(cli, name)
+		cli.pushEvent(newEvent(cli, event, stats.EVENT_STARTED))
+
+	case "stop", "die", "destroy":
+		// a crashed or removed container may never send stop, so any of these ends the tracking.
+		if cli.registry.Remove(name) {
+			log.Info.Printf("Container %s %s.", name, pastTense(action))
+		}
+
+		switch action {
+		case "stop":
+			cli.pushEvent(newEvent(cli, event, stats.EVENT_STOPPED))
+		case "die":
+			e := newEvent(cli, event, stats.EVENT_DIED)
+			e.ExitCode = event.Actor.Attributes.ExitCode
+			cli.pushEvent(e)
+		}
+
+	case "kill":
+		// the signal may not end the container, die will follow if it does.
+		log.Info.Printf("Container %s killed.", name)
+
+	case "oom":
+		// die will follow, the container is cleared then.
+		log.Warning.Printf("Container %s ran out of memory.", name)
+		cli.pushEvent(newEvent(cli, event, stats.EVENT_OOM_KILLED))
+
+	case "restart":
+		log.Info.Printf("Container %s restarted.", name)
+		cli.resetRates(name)
+		cli.pushEvent(newEvent(cli, event, stats.EVENT_RESTARTED))
+
+	case "pause", "unpause":
+		log.Info.Printf("Container %s %s.", name, pastTense(action))
+
+		cli.registry.Update(name, func(container *Container) {
+			container.Paused = action == "pause"
+		})
+
+	case "health_status":
+		log.Info.Printf("Container %s is %s.", name, status)
+
+		cli.registry.Update(name, func(container *Container) {
+			container.Health = status
+		})
+
+		e := newEvent(cli, event, stats.EVENT_HEALTH_CHANGED)
+		e.Health = status
+		cli.pushEvent(e)
+
+	case "rename":
+		if len(event.Actor.Attributes.OldName) < 2 {
 			return
-		default:
-			line, err := reader.ReadBytes('\n')
-			if err != nil {
-				log.Error.Printf("Events response error: %s", err.Error())
-			}
+		}
 
-			event := Event{}
-			err = json.Unmarshal(line, &event)
-			if err != nil {
-				log.Error.Printf("Events response error: %s", err.Error())
-			}
+		oldName := event.Actor.Attributes.OldName[1:]
+		log.Info.Printf("Container %s renamed to %s.", oldName, name)
 
-			if event.Type == "container" {
-				switch event.Action {
-				case "stop":
-					log.Info.Printf("Container %s stopped.", event.Actor.Attributes.Name)
-					delete(containers, event.Actor.Attributes.Name)
-					cli.repo.Clear(event.Actor.Attributes.Name)
-
-				case "start":
-					log.Info.Printf("Container %s started.", event.Actor.Attributes.Name)
-
-					// retrieve and store new container data.
-					container, err := cli.RequestContainer(event.Actor.Attributes.Name)
-					if err != nil {
-						log.Error.Printf("Cannot retrieve container data for %s. Error: %s",
-							event.Actor.Attributes.Name, err.Error())
-					}
-					containers[container.CanonicalName] = *container
-
-				case "rename":
-					oldName := event.Actor.Attributes.OldName[1:]
-					log.Info.Printf("Container %s renamed to %s.", oldName, event.Actor.Attributes.Name)
-
-					// delete registered container from map.
-					delete(containers, oldName)
-					cli.repo.Clear(oldName)
-
-					// retrieve and store new container data.
-					container, err := cli.RequestContainer(event.Actor.Attributes.Name)
-					if err != nil {
-						log.Error.Printf("Cannot retrieve container data for %s. Error: %s",
-							event.Actor.Attributes.Name, err.Error())
-					}
-					fmt.Println(container)
-					containers[container.CanonicalName] = *container
-				}
-			}
+		// retrieve the new container data, and replace the old one.
+		container, err := cli.RequestContainer(name)
+		if err != nil {
+			log.Error.Printf("Cannot retrieve container data for %s. Error: %s", name, err.Error())
+			cli.registry.Remove(oldName)
+		} else {
+			cli.registry.Rename(oldName, *container)
 		}
+
+		e := newEvent(cli, event, stats.EVENT_RENAMED)
+		e.OldName = oldName
+		cli.pushEvent(e)
+	}
+}
+
+// Builds the project event from a Docker event, with the given action.
+func newEvent(cli *Client, event Event, action string) *stats.Event {
+	return &stats.Event{
+		Timestamp: time.Unix(0, event.TimeNano),
+		Name:      event.Actor.Attributes.Name,
+		Host:      cli.host,
+		Action:    action,
+	}
+}
+
+// Retrieves and stores the data of the named container in the registry.
+func (em *EventsMonitor) track(cli *Client, name string) {
+	container, err := cli.RequestContainer(name)
+	if err != nil {
+		log.Error.Printf("Cannot retrieve container data for %s. Error: %s", name, err.Error())
+		return
 	}
+
+	cli.registry.Add(*container)
+}
+
+// Past tense of the container actions, for logging.
+func pastTense(action string) string {
+	switch action {
+	case "stop":
+		return "stopped"
+	case "die":
+		return "died"
+	case "destroy":
+		return "was destroyed"
+	case "pause":
+		return "paused"
+	case "unpause":
+		return "unpaused"
+	}
+
+	return action
 }
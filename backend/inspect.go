@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	INSPECT_QUERY = "/containers/%s/json"
+)
+
+// Refreshes the inspect data (health, restarts, limits, etc.) of the given container in the registry.
+func (cli *Client) Inspect(container Container) {
+	// client is closing, ignore the query.
+	if cli.ctx.Err() != nil {
+		return
+	}
+
+	if !cli.Connected() {
+		return
+	}
+
+	conn := <-cli.clients
+
+	cli.service.Send(Workload{
+		connection: conn,
+		container:  container,
+		kind:       WORKLOAD_INSPECT,
+	})
+
+	cli.clients <- conn
+}
+
+// Requests the inspect data of a container and updates it in the registry, if still tracked.
+func (cli *Client) processInspect(wl Workload) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf(INSPECT_QUERY, wl.container.CanonicalName), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := wl.connection.Do(req)
+	if err != nil {
+		cli.disconnected(err)
+		return err
+	}
+	defer res.Body.Close()
+
+	// the container is gone, the events monitor stops tracking it.
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Inspect request failed for " + wl.container.CanonicalName + ": " + res.Status)
+	}
+
+	inspect := &ContainerInspect{}
+	if err := json.NewDecoder(res.Body).Decode(inspect); err != nil {
+		return err
+	}
+
+	cli.registry.Update(wl.container.CanonicalName, inspect.apply)
+
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package backend
+
+import (
+	"errors"
+	"net"
+)
+
+// Named pipes are only available on Windows.
+func dialPipe(path string) (net.Conn, error) {
+	return nil, errors.New("Named pipes are only supported on Windows: " + path)
+}
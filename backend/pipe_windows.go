@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package backend
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Connection over a Windows named pipe, opened for overlapped I/O, so its reads and writes go through
+// the runtime poller: deadlines work, and closing it interrupts a blocked read.
+type pipeConn struct {
+	file *os.File
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "npipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// Opens the named pipe at the given path.
+func dialPipe(path string) (net.Conn, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// a plain open gives a synchronous handle, which nothing can interrupt once blocked on a read.
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	// the handle is overlapped, so the file is associated with the runtime poller (Go 1.25 onwards).
+	return &pipeConn{file: os.NewFile(uintptr(handle), path)}, nil
+}
+
+func (c *pipeConn) Read(b []byte) (int, error)  { return c.file.Read(b) }
+func (c *pipeConn) Write(b []byte) (int, error) { return c.file.Write(b) }
+func (c *pipeConn) Close() error                { return c.file.Close() }
+func (c *pipeConn) LocalAddr() net.Addr         { return pipeAddr(c.file.Name()) }
+func (c *pipeConn) RemoteAddr() net.Addr        { return pipeAddr(c.file.Name()) }
+
+func (c *pipeConn) SetDeadline(t time.Time) error      { return c.file.SetDeadline(t) }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return c.file.SetReadDeadline(t) }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return c.file.SetWriteDeadline(t) }
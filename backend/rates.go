@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"github.com/mijara/statspout/stats"
+)
+
+// Fills the rates of the given stats from the previous sample of the same container, which is then
+// replaced. The first sample of a container, and any counter that went back (it was reset), has a
+// rate of zero.
+func (cli *Client) calcRates(s *stats.Stats) {
+	cli.samplesLock.Lock()
+	defer cli.samplesLock.Unlock()
+
+	prev, ok := cli.samples[s.Name]
+
+	// the same frame can be pushed again on stream mode, so it keeps the rates it had.
+	if ok && !s.Timestamp.After(prev.Timestamp) {
+		s.RxBytesRate, s.TxBytesRate = prev.RxBytesRate, prev.TxBytesRate
+		s.RxPacketsRate, s.TxPacketsRate = prev.RxPacketsRate, prev.TxPacketsRate
+		s.BlockReadRate, s.BlockWriteRate = prev.BlockReadRate, prev.BlockWriteRate
+		return
+	}
+
+	cli.samples[s.Name] = s
+
+	if !ok {
+		return
+	}
+
+	seconds := s.Timestamp.Sub(prev.Timestamp).Seconds()
+
+	rate := func(current uint64, previous uint64) float64 {
+		if current < previous {
+			return 0
+		}
+		return float64(current-previous) / seconds
+	}
+
+	s.RxBytesRate = rate(s.RxBytesTotal, prev.RxBytesTotal)
+	s.TxBytesRate = rate(s.TxBytesTotal, prev.TxBytesTotal)
+	s.RxPacketsRate = rate(sumRxPackets(s.Networks), sumRxPackets(prev.Networks))
+	s.TxPacketsRate = rate(sumTxPackets(s.Networks), sumTxPackets(prev.Networks))
+	s.BlockReadRate = rate(s.BlockReadBytes, prev.BlockReadBytes)
+	s.BlockWriteRate = rate(s.BlockWriteBytes, prev.BlockWriteBytes)
+}
+
+// Forgets the previous sample of the named container, so rates start over.
+func (cli *Client) resetRates(name string) {
+	cli.samplesLock.Lock()
+	defer cli.samplesLock.Unlock()
+
+	delete(cli.samples, name)
+}
+
+func sumRxPackets(interfaces []stats.NetworkInterface) (sum uint64) {
+	for _, i := range interfaces {
+		sum += i.RxPackets
+	}
+	return
+}
+
+func sumTxPackets(interfaces []stats.NetworkInterface) (sum uint64) {
+	for _, i := range interfaces {
+		sum += i.TxPackets
+	}
+	return
+}
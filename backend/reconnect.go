@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"net/http/httputil"
+	"time"
+
+	"github.com/mijara/statspout/log"
+)
+
+const (
+	RECONNECT_MIN_BACKOFF = time.Second
+	RECONNECT_MAX_BACKOFF = time.Minute
+)
+
+// Whether the connections to the Docker host are working.
+func (cli *Client) Connected() bool {
+	cli.stateLock.Lock()
+	defer cli.stateLock.Unlock()
+
+	return cli.connected
+}
+
+// Marks the connections to the Docker host as broken, re-dialing them in background if that's
+// not happening already.
+func (cli *Client) disconnected(err error) {
+	cli.stateLock.Lock()
+	if !cli.connected || cli.ctx.Err() != nil {
+		cli.stateLock.Unlock()
+		return
+	}
+	cli.connected = false
+	cli.stateLock.Unlock()
+
+	log.Warning.Printf("Docker host %s disconnected: %s", cli.host, err.Error())
+
+	go cli.reconnect()
+}
+
+// Re-dials every connection with exponential backoff until the Docker host answers again, then
+// re-syncs the tracked containers.
+func (cli *Client) reconnect() {
+	start := time.Now()
+	backoff := RECONNECT_MIN_BACKOFF
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-cli.ctx.Done():
+			return
+		}
+
+		err := cli.redial()
+		if err == nil {
+			break
+		}
+
+		// double the waiting time for the next attempt, up to the maximum.
+		backoff *= 2
+		if backoff > RECONNECT_MAX_BACKOFF {
+			backoff = RECONNECT_MAX_BACKOFF
+		}
+
+		log.Warning.Printf("Reconnection attempt %d to %s failed, retrying in %s: %s",
+			attempt, cli.host, backoff, err.Error())
+	}
+
+	cli.stateLock.Lock()
+	cli.connected = true
+	cli.stateLock.Unlock()
+
+	log.Info.Printf("Docker host %s reconnected after %s.", cli.host, time.Since(start))
+
+	cli.resync()
+
+	// streams died with the connection, they will be opened again on the next query.
+	cli.stopStreams()
+}
+
+// Creates new connections for the daemons and side requests, replacing the broken ones. If a
+// single connection cannot be created, nothing is replaced.
+func (cli *Client) redial() error {
+	conns := make([]*httputil.ClientConn, 0, cli.daemons+1)
+
+	for i := 0; i < cli.daemons+1; i++ {
+		conn, err := createConn(cli.endpoint)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return err
+		}
+
+		conns = append(conns, httputil.NewClientConn(conn, nil))
+	}
+
+	// take every daemon connection out of the queue before giving the new ones back.
+	for i := 0; i < cli.daemons; i++ {
+		conn := <-cli.clients
+		conn.Close()
+	}
+
+	for _, conn := range conns[:cli.daemons] {
+		cli.clients <- conn
+	}
+
+	cli.connLock.Lock()
+	cli.dedicated.Close()
+	cli.dedicated = conns[cli.daemons]
+	cli.connLock.Unlock()
+
+	return nil
+}
+
+// Re-syncs the tracked containers with the ones running in the Docker host, since events could
+// have been missed while disconnected (from the daemon or the events stream).
+func (cli *Client) resync() {
+	if err := cli.Sync(); err != nil {
+		cli.onError(err)
+		return
+	}
+
+	log.Info.Printf("Docker host %s re-synced: %d containers.", cli.host, cli.registry.Len())
+}
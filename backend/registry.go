@@ -0,0 +1,174 @@
+package backend
+
+import (
+	"sort"
+	"sync"
+)
+
+// Callbacks to be notified of changes in the registry, any of them can be nil. They are called
+// after the change is done, outside of the registry lock, so they can use the registry.
+type Subscriber struct {
+	OnAdd    func(container Container)                 // a container started being tracked.
+	OnRemove func(container Container)                 // a container stopped being tracked.
+	OnRename func(oldName string, container Container) // a tracked container changed its name.
+}
+
+// Registry of tracked containers, by canonical name. It's safe to use from different goroutines,
+// iteration must be done over a snapshot.
+type Registry struct {
+	lock        sync.RWMutex
+	containers  map[string]Container
+	subscribers []Subscriber
+}
+
+// Creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		containers: make(map[string]Container),
+	}
+}
+
+// Adds a subscriber to be notified of every change from now on.
+func (r *Registry) Subscribe(s Subscriber) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.subscribers = append(r.subscribers, s)
+}
+
+// Gets the named container.
+func (r *Registry) Get(name string) (Container, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	container, ok := r.containers[name]
+	return container, ok
+}
+
+// Number of tracked containers.
+func (r *Registry) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return len(r.containers)
+}
+
+// Copy of the tracked containers, sorted by name, to be iterated freely.
+func (r *Registry) Snapshot() []Container {
+	r.lock.RLock()
+	containers := make([]Container, 0, len(r.containers))
+	for _, container := range r.containers {
+		containers = append(containers, container)
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].CanonicalName < containers[j].CanonicalName
+	})
+
+	return containers
+}
+
+// Adds or replaces a container, subscribers are only notified if it was not tracked.
+func (r *Registry) Add(container Container) {
+	r.lock.Lock()
+	_, ok := r.containers[container.CanonicalName]
+	r.containers[container.CanonicalName] = container
+	subscribers := r.subscribers
+	r.lock.Unlock()
+
+	if ok {
+		return
+	}
+
+	for _, s := range subscribers {
+		if s.OnAdd != nil {
+			s.OnAdd(container)
+		}
+	}
+}
+
+// Removes the named container, returning false if it was not tracked.
+func (r *Registry) Remove(name string) bool {
+	r.lock.Lock()
+	container, ok := r.containers[name]
+	delete(r.containers, name)
+	subscribers := r.subscribers
+	r.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	for _, s := range subscribers {
+		if s.OnRemove != nil {
+			s.OnRemove(container)
+		}
+	}
+
+	return true
+}
+
+// Replaces the container tracked as oldName with the given one. If oldName was not tracked, this
+// is the same as adding it.
+func (r *Registry) Rename(oldName string, container Container) {
+	r.lock.Lock()
+	_, ok := r.containers[oldName]
+	delete(r.containers, oldName)
+	r.containers[container.CanonicalName] = container
+	subscribers := r.subscribers
+	r.lock.Unlock()
+
+	if !ok {
+		for _, s := range subscribers {
+			if s.OnAdd != nil {
+				s.OnAdd(container)
+			}
+		}
+		return
+	}
+
+	for _, s := range subscribers {
+		if s.OnRename != nil {
+			s.OnRename(oldName, container)
+		}
+	}
+}
+
+// Modifies the named container in place, returning false if it was not tracked. Subscribers are
+// not notified.
+func (r *Registry) Update(name string, f func(container *Container)) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	container, ok := r.containers[name]
+	if !ok {
+		return false
+	}
+
+	f(&container)
+	r.containers[name] = container
+
+	return true
+}
+
+// Reconciles the registry with the given containers: the missing ones are removed, the new ones
+// added and the rest updated, keeping their inspect data.
+func (r *Registry) Sync(containers map[string]Container) {
+	for _, container := range r.Snapshot() {
+		if _, ok := containers[container.CanonicalName]; !ok {
+			r.Remove(container.CanonicalName)
+		}
+	}
+
+	for _, container := range containers {
+		tracked := r.Update(container.CanonicalName, func(c *Container) {
+			container.keepInspect(c)
+			*c = container
+		})
+
+		if !tracked {
+			r.Add(container)
+		}
+	}
+}
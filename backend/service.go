@@ -5,7 +5,7 @@ Spawns a number of daemons to listen for requests, which process in different go
 
 Example
 
-	service := NewService(10, MyRoutine, errorNotifier)
+	service := NewService(ctx, 10, MyRoutine, errorNotifier)
 
 	// will block until a daemon receives these messages (no process, just receive).
 	service.Send(99)
@@ -14,16 +14,17 @@ Example
 	service.Send("hello")
 	service.Send(42)
 
-	// will block until all daemons exit.
-	service.Close()
+	// will block until all daemons finish their work, or the context is done.
+	service.Close(ctx)
  */
 package backend
 
 import (
-	"time"
+	"context"
+	"errors"
+	"sync"
 
 	"github.com/mijara/statspout/log"
-	"errors"
 )
 
 type Routine func(interface{}) error
@@ -34,22 +35,26 @@ type Service struct {
 	r       Routine
 	errNot  ErrNotifier
 
-	closeChan chan bool
-	pipe      chan interface{}
+	ctx  context.Context
+	pipe chan interface{}
+	wg   sync.WaitGroup // running daemons.
 }
 
-func daemon(routine Routine, pipe chan interface{}, close chan bool, errNotifier ErrNotifier) {
+func daemon(s *Service) {
+	defer s.wg.Done()
+
 	defer func() {
 		if r := recover(); r != nil {
 			switch t := r.(type) {
 			case error:
-				errNotifier(t)
+				s.errNot(t)
 			case string:
-				errNotifier(errors.New(t))
+				s.errNot(errors.New(t))
 			}
 
 			// replace the dead daemon with a new one.
-			go daemon(routine, pipe, close, errNotifier)
+			s.wg.Add(1)
+			go daemon(s)
 
 			log.Info.Printf("Daemon died, spawned another one in place.")
 		}
@@ -57,44 +62,66 @@ func daemon(routine Routine, pipe chan interface{}, close chan bool, errNotifier
 
 	for {
 		select {
-		case <-close:
+		case <-s.ctx.Done():
 			return
-		case req := <-pipe:
-			if err := routine(req); err != nil {
-				errNotifier(err)
+		case req, ok := <-s.pipe:
+			if !ok {
+				return
+			}
+
+			if err := s.r(req); err != nil {
+				s.errNot(err)
 			}
 		}
 	}
 }
 
-func NewService(n int, r Routine, errNot ErrNotifier) *Service {
-	closeChan := make(chan bool)
-	pipe := make(chan interface{})
+// Creates the service with n daemons, which stop once the context is done or the service closed.
+func NewService(ctx context.Context, n int, r Routine, errNot ErrNotifier) *Service {
+	s := &Service{
+		daemons: n,
+		r:       r,
+		errNot:  errNot,
+		ctx:     ctx,
+		pipe:    make(chan interface{}),
+	}
 
 	for i := 0; i < n; i++ {
-		go daemon(r, pipe, closeChan, errNot)
+		s.wg.Add(1)
+		go daemon(s)
 	}
 
 	log.Info.Printf("%d daemons started.", n)
 
-	return &Service{
-		daemons:   n,
-		pipe:      pipe,
-		closeChan: closeChan,
-	}
+	return s
 }
 
-func (s *Service) Send(feed interface{}) {
-	s.pipe <- feed
-}
-
-func (s *Service) Close() {
-	for i := s.daemons; i > 0; i-- {
-		s.closeChan <- true
+// Sends the feed to the first daemon available, does nothing if the service context is done.
+// Returns whether a daemon received it.
+func (s *Service) Send(feed interface{}) bool {
+	select {
+	case s.pipe <- feed:
+		return true
+	case <-s.ctx.Done():
+		return false
 	}
+}
 
+// Stops receiving feeds and waits for the daemons to finish the ones in progress, or until the
+// given context is done. Send must not be called after this.
+func (s *Service) Close(ctx context.Context) error {
 	close(s.pipe)
-	close(s.closeChan)
 
-	time.Sleep(time.Millisecond * 500)
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+)
+
+const (
+	STREAM_QUERY = "/containers/%s/stats?stream=1"
+)
+
+// Stream holds a long-lived connection to the Docker Stats API for a single container, keeping the
+// last decoded frame so it can be emitted at the configured interval.
+type Stream struct {
+	container Container // container being streamed.
+
+	conn   net.Conn             // raw connection, closed to unblock the reader.
+	client *httputil.ClientConn // client connection used to open the stream.
+
+	backoff time.Duration // wait once the stream ends before opening it again.
+
+	lock     sync.Mutex      // guards last, received, alive and ended.
+	last     *ContainerStats // last frame decoded from the stream.
+	received time.Time       // time the last frame was received.
+	alive    bool            // whether frames are still being read.
+	ended    time.Time       // time the stream ended, zero while alive.
+}
+
+// Opens a new stats stream for the given container, frames are decoded on a separate goroutine. Once it
+// ends, it's opened again after the given backoff.
+func NewStream(endpoint Endpoint, container Container, backoff time.Duration) (*Stream, error) {
+	conn, err := createConn(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		container: container,
+		conn:      conn,
+		client:    httputil.NewClientConn(conn, nil),
+		backoff:   backoff,
+		alive:     true,
+	}
+
+	go s.loop()
+
+	return s, nil
+}
+
+// Returns the last frame received and when it was received, or nil if none has arrived yet.
+func (s *Stream) Last() (*ContainerStats, time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.last, s.received
+}
+
+// Whether the stream ended long enough ago to open it again, and the backoff of the new stream: doubled
+// if this one ended without sending any frame, otherwise reset.
+func (s *Stream) Retry() (time.Duration, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.alive || time.Since(s.ended) < s.backoff {
+		return 0, false
+	}
+
+	if s.last != nil {
+		return RECONNECT_MIN_BACKOFF, true
+	}
+
+	backoff := s.backoff * 2
+	if backoff > RECONNECT_MAX_BACKOFF {
+		backoff = RECONNECT_MAX_BACKOFF
+	}
+
+	return backoff, true
+}
+
+// Whether the stream is still reading frames.
+func (s *Stream) Alive() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.alive
+}
+
+// Closes the stream connection, which also stops the reading goroutine.
+func (s *Stream) Close() {
+	s.conn.Close()
+}
+
+func (s *Stream) loop() {
+	defer func() {
+		s.lock.Lock()
+		s.alive = false
+		s.ended = time.Now()
+		s.lock.Unlock()
+	}()
+	defer s.conn.Close()
+
+	req, err := http.NewRequest("GET", fmt.Sprintf(STREAM_QUERY, s.container.CanonicalName), nil)
+	if err != nil {
+		log.Error.Printf("Could not stream stats for %s: %s", s.container.CanonicalName, err.Error())
+		return
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		log.Error.Printf("Stats stream request failed for %s: %s", s.container.CanonicalName, err.Error())
+		return
+	}
+	defer res.Body.Close()
+
+	// an error, like a container that is gone, would decode into an empty frame.
+	if res.StatusCode != http.StatusOK {
+		log.Error.Printf("Stats stream request failed for %s: %s", s.container.CanonicalName, res.Status)
+		return
+	}
+
+	// frames are sent one after the other, so a decoder is enough to split them.
+	decoder := json.NewDecoder(res.Body)
+	for {
+		frame := &ContainerStats{}
+		if err := decoder.Decode(frame); err != nil {
+			// closing the connection ends up here as well.
+			return
+		}
+
+		s.lock.Lock()
+		s.last = frame
+		s.received = time.Now()
+		s.lock.Unlock()
+	}
+}
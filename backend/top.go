@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mijara/statspout/repo"
+	"github.com/mijara/statspout/stats"
+)
+
+const (
+	TOP_QUERY   = "/containers/%s/top?ps_args=%s"
+	TOP_PS_ARGS = "-eo pid,user,pcpu,pmem,rss,args"
+)
+
+// Process listing reported by the Docker Top API, each process has one value per title.
+type ContainerTop struct {
+	Titles    []string   `json:"Titles"`
+	Processes [][]string `json:"Processes"`
+}
+
+// Queries the top processes of the given container, only if the repository can store them.
+func (cli *Client) QueryProcesses(container Container) {
+	// client is closing, ignore the query.
+	if cli.ctx.Err() != nil {
+		return
+	}
+
+	if _, ok := cli.repo.(repo.ProcessInterface); !ok {
+		return
+	}
+
+	if !cli.Connected() {
+		return
+	}
+
+	conn := <-cli.clients
+
+	cli.service.Send(Workload{
+		connection: conn,
+		container:  container,
+		kind:       WORKLOAD_TOP,
+	})
+
+	cli.clients <- conn
+}
+
+// Requests the processes of a container and pushes the ones using more CPU to the repository.
+func (cli *Client) processTop(wl Workload) error {
+	query := fmt.Sprintf(TOP_QUERY, wl.container.CanonicalName, url.QueryEscape(TOP_PS_ARGS))
+
+	req, err := http.NewRequest("GET", query, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := wl.connection.Do(req)
+	if err != nil {
+		cli.disconnected(err)
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Top request failed for " + wl.container.CanonicalName + ": " + res.Status)
+	}
+
+	top := &ContainerTop{}
+	if err := json.NewDecoder(res.Body).Decode(top); err != nil {
+		return err
+	}
+
+	processes := parseProcesses(top)
+
+	sort.SliceStable(processes, func(i, j int) bool {
+		return processes[i].CpuPercent > processes[j].CpuPercent
+	})
+
+	if len(processes) > cli.options.TopCount {
+		processes = processes[:cli.options.TopCount]
+	}
+
+	return cli.repo.(repo.ProcessInterface).PushProcesses(&stats.Processes{
+		Timestamp: time.Now(),
+		Name:      wl.container.CanonicalName,
+		Host:      cli.host,
+		Processes: processes,
+	})
+}
+
+// Converts the processes of the Docker Top API, finding the values by their ps titles. Missing values
+// are left empty, since the titles depend on the platform of the container.
+func parseProcesses(top *ContainerTop) []stats.Process {
+	columns := make(map[string]int)
+	for i, title := range top.Titles {
+		columns[title] = i
+	}
+
+	value := func(process []string, title string) string {
+		i, ok := columns[title]
+		if !ok || i >= len(process) {
+			return ""
+		}
+		return process[i]
+	}
+
+	result := make([]stats.Process, 0, len(top.Processes))
+
+	for _, p := range top.Processes {
+		pid, _ := strconv.Atoi(value(p, "PID"))
+		cpu, _ := strconv.ParseFloat(value(p, "%CPU"), 64)
+		mem, _ := strconv.ParseFloat(value(p, "%MEM"), 64)
+		rss, _ := strconv.ParseUint(value(p, "RSS"), 10, 64)
+
+		result = append(result, stats.Process{
+			Pid:           pid,
+			User:          value(p, "USER"),
+			CpuPercent:    cpu,
+			MemoryPercent: mem,
+			Rss:           rss * 1024, // ps reports KiB.
+			Command:       value(p, "COMMAND"),
+		})
+	}
+
+	return result
+}
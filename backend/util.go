@@ -1,44 +1,268 @@
 package backend
 
-import "net"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
 
-// Creates a client for TCP (http) or Unix with the given address.
-func createConn(http bool, address string) (net.Conn, error) {
-	if http {
-		return net.Dial("tcp", address)
-	}
+	"github.com/mijara/statspout/stats"
+)
+
+func calcCpuPercent(stats *ContainerStats, normalized bool) float64 {
+	return cpuPercent(stats.Cpu.Usage.Total, stats.PreCpu.Usage.Total, stats, normalized)
+}
+
+// CPU percent spent in user space.
+func calcCpuUserPercent(stats *ContainerStats, normalized bool) float64 {
+	return cpuPercent(stats.Cpu.Usage.UsageInUsermode, stats.PreCpu.Usage.UsageInUsermode, stats, normalized)
+}
 
-	return net.Dial("unix", address)
+// CPU percent spent in the kernel.
+func calcCpuSystemPercent(stats *ContainerStats, normalized bool) float64 {
+	return cpuPercent(stats.Cpu.Usage.UsageInKernelmode, stats.PreCpu.Usage.UsageInKernelmode, stats, normalized)
 }
 
 // taken from: https://github.com/portainer/portainer/blob/develop/app/components/stats/statsController.js#L177-L193
-func calcCpuPercent(stats *ContainerStats) float64 {
+// The percent of the given usage is per core (up to N*100%), unless normalized to the whole host (up to 100%).
+func cpuPercent(usage uint64, preUsage uint64, stats *ContainerStats, normalized bool) float64 {
 	cpuPercent := 0.0
 
-	cpuDelta := float64(stats.Cpu.Usage.Total - stats.PreCpu.Usage.Total)
+	// counters may go back if the container restarted.
+	if usage < preUsage || stats.Cpu.SystemCpuUsage < stats.PreCpu.SystemCpuUsage {
+		return cpuPercent
+	}
+
+	cpuDelta := float64(usage - preUsage)
 	systemDelta := float64(stats.Cpu.SystemCpuUsage - stats.PreCpu.SystemCpuUsage)
 
 	if systemDelta > 0.0 && cpuDelta > 0.0 {
-		cpuPercent = (cpuDelta / systemDelta) * float64(len(stats.Cpu.Usage.PerCpu)) * 100.0
+		cpuPercent = (cpuDelta / systemDelta) * 100.0
+
+		if !normalized {
+			cpuPercent *= float64(onlineCpus(&stats.Cpu))
+		}
 	}
 
 	return cpuPercent
 }
 
+// Ratio of the CFS periods in which the container was throttled, since the previous read.
+func calcCpuThrottledRatio(stats *ContainerStats) float64 {
+	cur, pre := stats.Cpu.Throttling, stats.PreCpu.Throttling
+
+	if cur.Periods <= pre.Periods || cur.ThrottledPeriods < pre.ThrottledPeriods {
+		return 0.0
+	}
+
+	return float64(cur.ThrottledPeriods-pre.ThrottledPeriods) / float64(cur.Periods-pre.Periods)
+}
+
+// Number of CPUs of the host. percpu_usage is not sent on cgroup v2, and online_cpus is not sent by
+// older Docker versions.
+func onlineCpus(cpu *CpuStats) uint32 {
+	if cpu.OnlineCpus > 0 {
+		return cpu.OnlineCpus
+	}
+	return uint32(len(cpu.Usage.PerCpu))
+}
+
+// Well-known labels set by orchestrators on their containers.
+const (
+	LABEL_COMPOSE_PROJECT = "com.docker.compose.project"
+	LABEL_COMPOSE_SERVICE = "com.docker.compose.service"
+	LABEL_SWARM_SERVICE   = "com.docker.swarm.service.name"
+	LABEL_SWARM_TASK      = "com.docker.swarm.task.name"
+	LABEL_K8S_NAMESPACE   = "io.kubernetes.pod.namespace"
+	LABEL_K8S_POD         = "io.kubernetes.pod.name"
+	LABEL_K8S_CONTAINER   = "io.kubernetes.container.name"
+)
+
+// Identity metadata of the container, attached to every stats.
+func (container *Container) identity() stats.Identity {
+	shortID := container.ID
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
+	}
+
+	var created time.Time
+	if container.Created > 0 {
+		created = time.Unix(container.Created, 0)
+	}
+
+	return stats.Identity{
+		ID:             container.ID,
+		ShortID:        shortID,
+		Image:          container.Image,
+		ImageID:        container.ImageID,
+		Command:        container.Command,
+		Created:        created,
+		ComposeProject: container.Labels[LABEL_COMPOSE_PROJECT],
+		ComposeService: container.Labels[LABEL_COMPOSE_SERVICE],
+		SwarmService:   container.Labels[LABEL_SWARM_SERVICE],
+		SwarmTask:      container.Labels[LABEL_SWARM_TASK],
+		K8sNamespace:   container.Labels[LABEL_K8S_NAMESPACE],
+		K8sPod:         container.Labels[LABEL_K8S_POD],
+		K8sContainer:   container.Labels[LABEL_K8S_CONTAINER],
+	}
+}
+
+// Configured CPU limit in cores, given by --cpus or by the CFS quota and period, zero if unlimited.
+func calcCpuLimit(ci *ContainerInspect) float64 {
+	if ci.HostConfig.NanoCpus > 0 {
+		return float64(ci.HostConfig.NanoCpus) / 1e9
+	}
+
+	if ci.HostConfig.CpuQuota > 0 {
+		period := ci.HostConfig.CpuPeriod
+		if period <= 0 {
+			period = 100000 // default CFS period, in microseconds.
+		}
+		return float64(ci.HostConfig.CpuQuota) / float64(period)
+	}
+
+	return 0.0
+}
+
+// Limit of processes, zero if unlimited.
+func calcPidsLimit(pids *PidsStats) uint64 {
+	if pids.Limit == math.MaxUint64 {
+		return 0
+	}
+	return pids.Limit
+}
+
+// Percent of the working set over the limit, zero if there's no limit reported.
 func calcMemoryPercent(stats *ContainerStats) float64 {
-	return float64(stats.Memory.Usage) * 100.0 / float64(stats.Memory.Limit)
+	if stats.Memory.Limit == 0 {
+		return 0.0
+	}
+
+	return float64(calcMemoryWorkingSet(&stats.Memory)) * 100.0 / float64(stats.Memory.Limit)
 }
 
-func sumTxBytesTotal(interfaces map[string]InterfaceStats) (sum uint32) {
+// Memory usage without the inactive page cache, which the kernel can reclaim, the same way docker
+// stats does: total_inactive_file on cgroup v1 and inactive_file on cgroup v2.
+func calcMemoryWorkingSet(memory *MemoryStats) uint64 {
+	inactive := memory.Stats.TotalInactiveFile
+	if inactive == 0 {
+		inactive = memory.Stats.InactiveFile
+	}
+
+	if inactive > memory.Usage {
+		return memory.Usage
+	}
+
+	return memory.Usage - inactive
+}
+
+// Anonymous memory, rss on cgroup v1 and anon on cgroup v2.
+func calcMemoryRss(memory *MemoryStats) uint64 {
+	if memory.Stats.Rss > 0 {
+		return memory.Stats.Rss
+	}
+	return memory.Stats.Anon
+}
+
+// Page cache, cache on cgroup v1 and file on cgroup v2.
+func calcMemoryCache(memory *MemoryStats) uint64 {
+	if memory.Stats.Cache > 0 {
+		return memory.Stats.Cache
+	}
+	return memory.Stats.File
+}
+
+func sumTxBytesTotal(interfaces map[string]InterfaceStats) (sum uint64) {
 	for _, i := range interfaces {
 		sum += i.TxBytes
 	}
 	return
 }
 
-func sumRxBytesTotal(interfaces map[string]InterfaceStats) (sum uint32) {
+func sumRxBytesTotal(interfaces map[string]InterfaceStats) (sum uint64) {
 	for _, i := range interfaces {
 		sum += i.RxBytes
 	}
 	return
 }
+
+// Converts the stats of every network interface, sorted by interface name.
+func networkInterfaces(interfaces map[string]InterfaceStats) []stats.NetworkInterface {
+	result := make([]stats.NetworkInterface, 0, len(interfaces))
+
+	for name, i := range interfaces {
+		result = append(result, stats.NetworkInterface{
+			Interface: name,
+			RxBytes:   i.RxBytes,
+			RxPackets: i.RxPackets,
+			RxErrors:  i.RxErrors,
+			RxDropped: i.RxDropped,
+			TxBytes:   i.TxBytes,
+			TxPackets: i.TxPackets,
+			TxErrors:  i.TxErrors,
+			TxDropped: i.TxDropped,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Interface < result[j].Interface
+	})
+
+	return result
+}
+
+// Sums the read and write values of every device, the op is "Read" or "Write" on cgroup v1 and lowercase on v2.
+func sumBlkio(entries []BlkioEntry) (read uint64, write uint64) {
+	for _, e := range entries {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			read += e.Value
+		case "write":
+			write += e.Value
+		}
+	}
+	return
+}
+
+// Breaks down the block I/O stats by device, sorted by device number.
+func blkioDevices(blkio *BlkioStats) []stats.BlockDevice {
+	devices := make(map[string]*stats.BlockDevice)
+
+	device := func(e BlkioEntry) *stats.BlockDevice {
+		name := fmt.Sprintf("%d:%d", e.Major, e.Minor)
+		if _, ok := devices[name]; !ok {
+			devices[name] = &stats.BlockDevice{Device: name}
+		}
+		return devices[name]
+	}
+
+	for _, e := range blkio.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			device(e).ReadBytes += e.Value
+		case "write":
+			device(e).WriteBytes += e.Value
+		}
+	}
+
+	for _, e := range blkio.IoServicedRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			device(e).ReadOps += e.Value
+		case "write":
+			device(e).WriteOps += e.Value
+		}
+	}
+
+	result := make([]stats.BlockDevice, 0, len(devices))
+	for _, d := range devices {
+		result = append(result, *d)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Device < result[j].Device
+	})
+
+	return result
+}
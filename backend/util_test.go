@@ -0,0 +1,186 @@
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// Loads a recorded response of the Docker Stats API from the testdata directory.
+func loadStats(t *testing.T, name string) *ContainerStats {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := &ContainerStats{}
+	if err := json.Unmarshal(data, cs); err != nil {
+		t.Fatal(err)
+	}
+
+	return cs
+}
+
+func TestCalcCpuPercent(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		normalized bool
+		want       float64
+	}{
+		{"cgroup v1 per core", "stats_cgroup_v1.json", false, 10.0},
+		{"cgroup v1 host", "stats_cgroup_v1.json", true, 2.5},
+		{"cgroup v2 per core", "stats_cgroup_v2.json", false, 50.0},
+		{"cgroup v2 host", "stats_cgroup_v2.json", true, 6.25},
+		{"percpu_usage fallback per core", "stats_no_online_cpus.json", false, 20.0},
+		{"percpu_usage fallback host", "stats_no_online_cpus.json", true, 10.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calcCpuPercent(loadStats(t, tt.payload), tt.normalized)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("calcCpuPercent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalcCpuPercentEdgeCases(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats ContainerStats
+		want  float64
+	}{
+		{
+			name:  "first sample without precpu",
+			stats: ContainerStats{Cpu: CpuStats{Usage: CpuUsage{Total: 100}, SystemCpuUsage: 1000, OnlineCpus: 2}},
+			want:  20.0,
+		},
+		{
+			name: "no system delta",
+			stats: ContainerStats{
+				Cpu:    CpuStats{Usage: CpuUsage{Total: 200}, SystemCpuUsage: 1000, OnlineCpus: 2},
+				PreCpu: CpuStats{Usage: CpuUsage{Total: 100}, SystemCpuUsage: 1000, OnlineCpus: 2},
+			},
+			want: 0.0,
+		},
+		{
+			name: "counters reset",
+			stats: ContainerStats{
+				Cpu:    CpuStats{Usage: CpuUsage{Total: 100}, SystemCpuUsage: 2000, OnlineCpus: 2},
+				PreCpu: CpuStats{Usage: CpuUsage{Total: 500}, SystemCpuUsage: 1000, OnlineCpus: 2},
+			},
+			want: 0.0,
+		},
+		{
+			name: "no cpus reported",
+			stats: ContainerStats{
+				Cpu:    CpuStats{Usage: CpuUsage{Total: 200}, SystemCpuUsage: 2000},
+				PreCpu: CpuStats{Usage: CpuUsage{Total: 100}, SystemCpuUsage: 1000},
+			},
+			want: 0.0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calcCpuPercent(&tt.stats, false)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("calcCpuPercent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalcCpuModePercent(t *testing.T) {
+	tests := []struct {
+		payload string
+		user    float64
+		system  float64
+	}{
+		{"stats_cgroup_v1.json", 9.0, 1.0},
+		{"stats_cgroup_v2.json", 40.0, 10.0},
+		{"stats_no_online_cpus.json", 17.0, 3.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			cs := loadStats(t, tt.payload)
+
+			if got := calcCpuUserPercent(cs, false); math.Abs(got-tt.user) > 1e-9 {
+				t.Errorf("calcCpuUserPercent() = %v, want %v", got, tt.user)
+			}
+
+			if got := calcCpuSystemPercent(cs, false); math.Abs(got-tt.system) > 1e-9 {
+				t.Errorf("calcCpuSystemPercent() = %v, want %v", got, tt.system)
+			}
+		})
+	}
+}
+
+func TestCalcCpuThrottledRatio(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    float64
+	}{
+		{"stats_cgroup_v1.json", 0.0},
+		{"stats_cgroup_v2.json", 0.1},
+		{"stats_no_online_cpus.json", 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			if got := calcCpuThrottledRatio(loadStats(t, tt.payload)); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("calcCpuThrottledRatio() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnlineCpus(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    uint32
+	}{
+		{"stats_cgroup_v1.json", 4},
+		{"stats_cgroup_v2.json", 8},
+		{"stats_no_online_cpus.json", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			if got := onlineCpus(&loadStats(t, tt.payload).Cpu); got != tt.want {
+				t.Errorf("onlineCpus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalcMemory(t *testing.T) {
+	tests := []struct {
+		payload    string
+		workingSet uint64
+		percent    float64
+	}{
+		{"stats_cgroup_v1.json", 36864000, 36864000 * 100.0 / 2096058368},
+		{"stats_cgroup_v2.json", 33271808, 33271808 * 100.0 / 8323002368},
+		{"stats_no_online_cpus.json", 10485760, 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			cs := loadStats(t, tt.payload)
+
+			if got := calcMemoryWorkingSet(&cs.Memory); got != tt.workingSet {
+				t.Errorf("calcMemoryWorkingSet() = %v, want %v", got, tt.workingSet)
+			}
+
+			if got := calcMemoryPercent(cs); math.Abs(got-tt.percent) > 1e-9 {
+				t.Errorf("calcMemoryPercent() = %v, want %v", got, tt.percent)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/mijara/statspout/repo"
+)
+
+// HTTP endpoint publishing the status of the repositories.
+type HealthServer struct {
+	server *http.Server
+}
+
+type HealthOpts struct {
+	Address string // address of the endpoint, disabled if empty.
+	Path    string
+}
+
+// Body of the health endpoint.
+type healthReport struct {
+	Healthy      bool          `json:"healthy"`
+	Repositories []repo.Status `json:"repositories"`
+}
+
+// Starts the health endpoint of the given health checks, it answers 503 Service Unavailable while
+// any repository is unhealthy.
+func NewHealthServer(opts *HealthOpts, health *repo.Health) (*HealthServer, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(checkAndFixPrefixSlash(opts.Path), func(w http.ResponseWriter, r *http.Request) {
+		report := healthReport{Healthy: true, Repositories: health.Statuses()}
+		for _, status := range report.Repositories {
+			if !status.Healthy {
+				report.Healthy = false
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if report.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(report)
+	})
+
+	hs := &HealthServer{server: newServer(opts.Address, mux)}
+
+	if err := serve(hs.server); err != nil {
+		return nil, err
+	}
+
+	return hs, nil
+}
+
+// Stops the health endpoint, until the given context is done.
+func (hs *HealthServer) Close(ctx context.Context) error {
+	return hs.server.Shutdown(ctx)
+}
+
+// Does the given check, giving up once the context is done, for checks that can't be cancelled. The check
+// keeps running on its own goroutine in that case, until it returns.
+func checkWithContext(ctx context.Context, check func() error) error {
+	errC := make(chan error, 1)
+
+	go func() {
+		errC <- check()
+	}()
+
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
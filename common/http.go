@@ -0,0 +1,38 @@
+package common
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/mijara/statspout/log"
+)
+
+// Creates an HTTP Server for the given address and handler, so it can be shut down.
+func newServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:    address,
+		Handler: handler,
+	}
+}
+
+// Starts the HTTP Server in background, a shut down server is not considered an error. It listens right
+// away, so an address already in use, for instance by another repository, is returned to the caller.
+func serve(server *http.Server) error {
+	address := server.Addr
+	if address == "" {
+		address = ":http"
+	}
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := server.Serve(l); err != http.ErrServerClosed {
+			log.Error.Fatal(err)
+		}
+	}()
+
+	return nil
+}
@@ -1,13 +1,20 @@
 package common
 
 import (
+	"context"
 	"flag"
+	"math"
+	"time"
 
 	"github.com/influxdata/influxdb/client/v2"
 	"github.com/mijara/statspout/repo"
 	"github.com/mijara/statspout/stats"
 )
 
+const (
+	INFLUXDB_TIMEOUT = 30 * time.Second // maximum time of a request to the server, so a server not answering doesn't hang.
+)
+
 type InfluxDB struct {
 	client   client.Client
 	database string
@@ -20,7 +27,7 @@ type InfluxOpts struct {
 
 // Creates a new InfluxDB repository.
 func NewInfluxDB(opts *InfluxOpts) (*InfluxDB, error) {
-	c, err := client.NewHTTPClient(client.HTTPConfig{Addr: opts.Address})
+	c, err := client.NewHTTPClient(client.HTTPConfig{Addr: opts.Address, Timeout: INFLUXDB_TIMEOUT})
 	if err != nil {
 		return nil, err
 	}
@@ -36,25 +43,248 @@ func (*InfluxDB) Create(v interface{}) (repo.Interface, error) {
 }
 
 func (influx *InfluxDB) Push(s *stats.Stats) error {
-	if err := influx.pushResource(s, "cpu_usage", s.CpuPercent); err != nil {
+	return influx.PushBatch([]*stats.Stats{s})
+}
+
+// Pushes the stats of many containers on a single write.
+func (influx *InfluxDB) PushBatch(batch []*stats.Stats) error {
+	bp, err := influx.newBatchPoints()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range batch {
+		if err := influx.addStats(bp, s); err != nil {
+			return err
+		}
+	}
+
+	return influx.client.Write(bp)
+}
+
+// Adds the points of the stats to the batch.
+func (influx *InfluxDB) addStats(bp client.BatchPoints, s *stats.Stats) error {
+	if err := influx.addResource(bp, s, "paused", s.Paused); err != nil {
+		return err
+	}
+
+	// a paused container has no CPU usage to report.
+	if !s.Paused {
+		if err := influx.addResource(bp, s, "cpu_usage", s.CpuPercent); err != nil {
+			return err
+		}
+
+		if err := influx.addResource(bp, s, "cpu_user", s.CpuUserPercent); err != nil {
+			return err
+		}
+
+		if err := influx.addResource(bp, s, "cpu_system", s.CpuSystemPercent); err != nil {
+			return err
+		}
+
+		if err := influx.addResource(bp, s, "cpu_throttled_ratio", s.CpuThrottledRatio); err != nil {
+			return err
+		}
+	}
+
+	if err := influx.addResource(bp, s, "cpu_throttled_time", integer(s.CpuThrottledTime)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "mem_usage", s.MemoryPercent); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "mem_rss", integer(s.MemoryRss)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "mem_cache", integer(s.MemoryCache)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "mem_swap", integer(s.MemorySwap)); err != nil {
 		return err
 	}
 
-	if err := influx.pushResource(s, "mem_usage", s.MemoryPercent); err != nil {
+	if err := influx.addResource(bp, s, "mem_working_set", integer(s.MemoryWorkingSet)); err != nil {
 		return err
 	}
 
-	if err := influx.pushResource(s, "tx_bytes", s.TxBytesTotal); err != nil {
+	if err := influx.addResource(bp, s, "mem_limit", integer(s.MemoryLimit)); err != nil {
 		return err
 	}
 
-	if err := influx.pushResource(s, "rx_bytes", s.RxBytesTotal); err != nil {
+	if err := influx.addResource(bp, s, "pids", integer(s.Pids)); err != nil {
 		return err
 	}
 
+	if err := influx.addResource(bp, s, "pids_limit", integer(s.PidsLimit)); err != nil {
+		return err
+	}
+
+	// the health is only reported for containers with a health check.
+	if s.Health != "" {
+		if err := influx.addResource(bp, s, "health", s.Health); err != nil {
+			return err
+		}
+	}
+
+	if err := influx.addResource(bp, s, "restart_count", s.RestartCount); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "oom_killed", s.OOMKilled); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "uptime", s.Uptime); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "cpu_limit", s.CpuLimit); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "mem_limit_configured", integer(s.MemoryLimitConfigured)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "tx_bytes", integer(s.TxBytesTotal)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "rx_bytes", integer(s.RxBytesTotal)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "rx_bytes_rate", s.RxBytesRate); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "tx_bytes_rate", s.TxBytesRate); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "rx_packets_rate", s.RxPacketsRate); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "tx_packets_rate", s.TxPacketsRate); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "blkio_read_bytes", integer(s.BlockReadBytes)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "blkio_write_bytes", integer(s.BlockWriteBytes)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "blkio_read_ops", integer(s.BlockReadOps)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "blkio_write_ops", integer(s.BlockWriteOps)); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "blkio_read_rate", s.BlockReadRate); err != nil {
+		return err
+	}
+
+	if err := influx.addResource(bp, s, "blkio_write_rate", s.BlockWriteRate); err != nil {
+		return err
+	}
+
+	if len(s.BlockDevices) > 0 {
+		if err := influx.addDevices(bp, s); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Networks) > 0 {
+		if err := influx.addNetworks(bp, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Adds the block I/O breakdown to the blkio_device measurement, one point per device, using the
+// device as a tag along the container tags.
+func (influx *InfluxDB) addDevices(bp client.BatchPoints, s *stats.Stats) error {
+	for _, d := range s.BlockDevices {
+		tags := containerTags(s)
+		tags["device"] = d.Device
+		fields := map[string]interface{}{
+			"read_bytes":  integer(d.ReadBytes),
+			"write_bytes": integer(d.WriteBytes),
+			"read_ops":    integer(d.ReadOps),
+			"write_ops":   integer(d.WriteOps),
+		}
+
+		pt, err := client.NewPoint("blkio_device", tags, fields, s.Timestamp)
+		if err != nil {
+			return err
+		}
+
+		bp.AddPoint(pt)
+	}
+
+	return nil
+}
+
+// Adds the network stats to the network measurement, one point per interface, using the
+// interface as a tag along the container tags.
+func (influx *InfluxDB) addNetworks(bp client.BatchPoints, s *stats.Stats) error {
+	for _, n := range s.Networks {
+		tags := containerTags(s)
+		tags["interface"] = n.Interface
+		fields := map[string]interface{}{
+			"rx_bytes":   integer(n.RxBytes),
+			"rx_packets": integer(n.RxPackets),
+			"rx_errors":  integer(n.RxErrors),
+			"rx_dropped": integer(n.RxDropped),
+			"tx_bytes":   integer(n.TxBytes),
+			"tx_packets": integer(n.TxPackets),
+			"tx_errors":  integer(n.TxErrors),
+			"tx_dropped": integer(n.TxDropped),
+		}
+
+		pt, err := client.NewPoint("network", tags, fields, s.Timestamp)
+		if err != nil {
+			return err
+		}
+
+		bp.AddPoint(pt)
+	}
+
 	return nil
 }
 
+// Pushes the event to the events measurement, as an annotation with the container, host and action as tags.
+func (influx *InfluxDB) PushEvent(e *stats.Event) error {
+	bp, err := influx.newBatchPoints()
+	if err != nil {
+		return err
+	}
+
+	tags := map[string]string{"container": e.Name, "host": e.Host, "action": e.Action}
+	fields := map[string]interface{}{"title": e.Action, "text": e.Description()}
+
+	pt, err := client.NewPoint("events", tags, fields, e.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	bp.AddPoint(pt)
+
+	return influx.client.Write(bp)
+}
+
 func CreateInfluxDBOpts() *InfluxOpts {
 	o := &InfluxOpts{}
 
@@ -71,43 +301,62 @@ func CreateInfluxDBOpts() *InfluxOpts {
 	return o
 }
 
+// Pings the InfluxDB server, until the given context is done.
+func (influx *InfluxDB) Ping(ctx context.Context) error {
+	return checkWithContext(ctx, func() error {
+		_, _, err := influx.client.Ping(0)
+		return err
+	})
+}
+
 func (*InfluxDB) Name() string {
 	return "influxdb"
 }
 
-func (influx *InfluxDB) Close() {
-	influx.client.Close()
+func (influx *InfluxDB) Close(ctx context.Context) error {
+	return influx.client.Close()
 }
 
-func (influx *InfluxDB) Clear(name string) {
+func (influx *InfluxDB) Clear(host string, name string) {
 	// not used.
 }
 
-// Pushes certain a single value to the database, using the resource as the name and
-// the name of the container as a tag.
-func (influx *InfluxDB) pushResource(s *stats.Stats, resource string, value interface{}) error {
-	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  influx.database,
-		Precision: "s",
-	})
-	if err != nil {
-		return err
-	}
+// Tags of every point of the stats: the container, its host and its identity metadata.
+func containerTags(s *stats.Stats) map[string]string {
+	tags := s.Identity.Tags()
+	tags["container"] = s.Name
+	tags["host"] = s.Host
 
-	tags := map[string]string{"container": s.Name}
-	fields := map[string]interface{}{"value": value}
+	return tags
+}
 
-	pt, err := client.NewPoint(resource, tags, fields, s.Timestamp)
-	if err != nil {
-		return err
+// Converts a counter to an integer field, since unsigned fields are not supported by every InfluxDB
+// version. Counters never get close to the limit, but it's clamped anyway.
+func integer(v uint64) int64 {
+	if v > math.MaxInt64 {
+		return math.MaxInt64
 	}
 
-	bp.AddPoint(pt)
+	return int64(v)
+}
 
-	err = influx.client.Write(bp)
+// Adds a single value to the batch, using the resource as the name and the name of the container,
+// its host and its identity as tags.
+func (influx *InfluxDB) addResource(bp client.BatchPoints, s *stats.Stats, resource string, value interface{}) error {
+	pt, err := client.NewPoint(resource, containerTags(s), map[string]interface{}{"value": value}, s.Timestamp)
 	if err != nil {
 		return err
 	}
 
+	bp.AddPoint(pt)
+
 	return nil
 }
+
+// Creates an empty batch of points for the database.
+func (influx *InfluxDB) newBatchPoints() (client.BatchPoints, error) {
+	return client.NewBatchPoints(client.BatchPointsConfig{
+		Database:  influx.database,
+		Precision: "s",
+	})
+}
@@ -1,7 +1,10 @@
 package common
 
 import (
+	"context"
 	"flag"
+	"sync"
+	"time"
 
 	"gopkg.in/mgo.v2"
 
@@ -9,28 +12,39 @@ import (
 	"github.com/mijara/statspout/stats"
 )
 
+const (
+	MONGO_DIAL_TIMEOUT = 10 * time.Second // maximum time to connect to the server.
+)
+
+// Repository which inserts the stats as documents. It connects on the first use, so an unreachable server
+// doesn't stop it from starting, and is retried on each use until connected.
 type Mongo struct {
-	session    *mgo.Session
+	address string
+
+	lock    sync.Mutex   // guards session.
+	session *mgo.Session // nil until connected.
+
 	database   string
 	collection string
+	events     string
+	processes  string
 }
 
 type MongoOpts struct {
 	Address    string
 	Database   string
 	Collection string
+	Events     string
+	Processes  string
 }
 
 func NewMongo(opts *MongoOpts) (*Mongo, error) {
-	session, err := mgo.Dial(opts.Address)
-	if err != nil {
-		return nil, err
-	}
-
 	return &Mongo{
-		session:    session,
+		address:    opts.Address,
 		database:   opts.Database,
 		collection: opts.Collection,
+		events:     opts.Events,
+		processes:  opts.Processes,
 	}, nil
 }
 
@@ -39,25 +53,94 @@ func (*Mongo) Create(v interface{}) (repo.Interface, error) {
 }
 
 func (mongo *Mongo) Push(s *stats.Stats) error {
-	c := mongo.session.DB(mongo.database).C(mongo.collection)
+	return mongo.insert(mongo.collection, s)
+}
+
+// Inserts the stats of many containers on a single insert.
+func (mongo *Mongo) PushBatch(batch []*stats.Stats) error {
+	docs := make([]interface{}, len(batch))
+	for i, s := range batch {
+		docs[i] = s
+	}
 
-	err := c.Insert(s)
+	return mongo.insert(mongo.collection, docs...)
+}
+
+func (mongo *Mongo) PushEvent(e *stats.Event) error {
+	return mongo.insert(mongo.events, e)
+}
+
+func (mongo *Mongo) PushProcesses(p *stats.Processes) error {
+	return mongo.insert(mongo.processes, p)
+}
+
+// Inserts the documents on the given collection. The session is refreshed if it fails, so it reconnects
+// once the server is back, even without health checks.
+func (mongo *Mongo) insert(collection string, docs ...interface{}) error {
+	session, err := mongo.connect()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = session.DB(mongo.database).C(collection).Insert(docs...)
+	if err != nil {
+		session.Refresh()
+	}
+
+	return err
+}
+
+// Pings the MongoDB server, until the given context is done. The session is refreshed if it fails, so it
+// reconnects once the server is back.
+func (mongo *Mongo) Ping(ctx context.Context) error {
+	return checkWithContext(ctx, func() error {
+		session, err := mongo.connect()
+		if err != nil {
+			return err
+		}
+
+		err = session.Ping()
+		if err != nil {
+			session.Refresh()
+		}
+
+		return err
+	})
 }
 
 func (*Mongo) Name() string {
 	return "mongodb"
 }
 
-func (mongo *Mongo) Close() {
-	mongo.session.Close()
+func (mongo *Mongo) Close(ctx context.Context) error {
+	mongo.lock.Lock()
+	defer mongo.lock.Unlock()
+
+	if mongo.session != nil {
+		mongo.session.Close()
+	}
+
+	return nil
+}
+
+// Session to the server, connecting first if not connected yet.
+func (mongo *Mongo) connect() (*mgo.Session, error) {
+	mongo.lock.Lock()
+	defer mongo.lock.Unlock()
+
+	if mongo.session == nil {
+		session, err := mgo.DialWithTimeout(mongo.address, MONGO_DIAL_TIMEOUT)
+		if err != nil {
+			return nil, err
+		}
+
+		mongo.session = session
+	}
+
+	return mongo.session, nil
 }
 
-func (mongo *Mongo) Clear(name string) {
+func (mongo *Mongo) Clear(host string, name string) {
 	// not used.
 }
 
@@ -79,5 +162,15 @@ func CreateMongoOpts() *MongoOpts {
 		"stats",
 		"Collection for the stats")
 
+	flag.StringVar(&o.Events,
+		"mongo.events",
+		"events",
+		"Collection for the container events")
+
+	flag.StringVar(&o.Processes,
+		"mongo.processes",
+		"processes",
+		"Collection for the container process listings")
+
 	return o
 }
@@ -1,9 +1,11 @@
 package common
 
 import (
+	"context"
 	"net/http"
-	"log"
 	"flag"
+	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,11 +16,104 @@ import (
 
 type Prometheus struct {
 	cpuUsagePercent    *prometheus.GaugeVec
+	cpuUserPercent     *prometheus.GaugeVec
+	cpuSystemPercent   *prometheus.GaugeVec
+	cpuThrottledRatio  *prometheus.GaugeVec
+	cpuThrottledTime   *counterVec
 	memoryUsagePercent *prometheus.GaugeVec
-	txBytesTotal       *prometheus.GaugeVec
-	rxBytesTotal       *prometheus.GaugeVec
+	txBytesTotal       *counterVec
+	rxBytesTotal       *counterVec
+	paused             *prometheus.GaugeVec
+
+	memoryRss        *prometheus.GaugeVec
+	memoryCache      *prometheus.GaugeVec
+	memorySwap       *prometheus.GaugeVec
+	memoryWorkingSet *prometheus.GaugeVec
+	memoryLimit      *prometheus.GaugeVec
+
+	pids      *prometheus.GaugeVec
+	pidsLimit *prometheus.GaugeVec
+
+	health                *prometheus.GaugeVec
+	restartCount          *prometheus.GaugeVec
+	oomKilled             *prometheus.GaugeVec
+	uptime                *prometheus.GaugeVec
+	cpuLimit              *prometheus.GaugeVec
+	memoryLimitConfigured *prometheus.GaugeVec
+
+	blkioReadBytes  *counterVec
+	blkioWriteBytes *counterVec
+	blkioReadOps    *counterVec
+	blkioWriteOps   *counterVec
+
+	blkioDeviceReadBytes  *counterVec
+	blkioDeviceWriteBytes *counterVec
+	blkioDeviceReadOps    *counterVec
+	blkioDeviceWriteOps   *counterVec
+
+	netRxBytes   *counterVec
+	netRxPackets *counterVec
+	netRxErrors  *counterVec
+	netRxDropped *counterVec
+	netTxBytes   *counterVec
+	netTxPackets *counterVec
+	netTxErrors  *counterVec
+	netTxDropped *counterVec
+
+	info *prometheus.GaugeVec
+
+	devices    map[string][]string // block devices reported by each container, to clear them.
+	interfaces map[string][]string // network interfaces reported by each container, to clear them.
+	infos      map[string][]string // info label values of each container, to clear them.
+	labelsLock sync.Mutex          // guards devices, interfaces and infos.
+
+	server *http.Server
+}
+
+// Counter fed with the cumulative totals reported by Docker. Only the increase since the last total
+// is added, so when a total goes back (the container restarted, resetting its counters) the new
+// total is taken as the increase, and the exported counter remains monotonic.
+type counterVec struct {
+	vec  *prometheus.CounterVec
+	last map[string]float64 // last total of each series, by joined labels.
+	unit float64            // multiplier of the totals, to export them in base units.
+	lock sync.Mutex
 }
 
+// Updates the series with the given labels from a new total.
+func (c *counterVec) set(total uint64, labels ...string) {
+	key := strings.Join(labels, "/")
+	value := float64(total) * c.unit
+
+	c.lock.Lock()
+	last, ok := c.last[key]
+	c.last[key] = value
+	c.lock.Unlock()
+
+	increase := value
+	if ok && value >= last {
+		increase = value - last
+	}
+
+	c.vec.WithLabelValues(labels...).Add(increase)
+}
+
+// Removes the series with the given labels.
+func (c *counterVec) delete(labels ...string) {
+	c.lock.Lock()
+	delete(c.last, strings.Join(labels, "/"))
+	c.lock.Unlock()
+
+	c.vec.DeleteLabelValues(labels...)
+}
+
+// Labels of the info metric, besides host and container.
+var infoLabels = []string{"id", "image", "image_id", "compose_project", "compose_service", "swarm_service",
+	"swarm_task", "k8s_namespace", "k8s_pod", "k8s_container"}
+
+// Health statuses reported by Docker, each one is exported as a label value.
+var healthStatuses = []string{"starting", "healthy", "unhealthy"}
+
 type PrometheusOpts struct {
 	Address string
 }
@@ -31,83 +126,344 @@ func (*Prometheus) Create(v interface{}) (repo.Interface, error) {
 	return NewPrometheus(v.(*PrometheusOpts))
 }
 
-func (prom *Prometheus) Clear(name string) {
-	prom.cpuUsagePercent.DeleteLabelValues(name)
-	prom.memoryUsagePercent.DeleteLabelValues(name)
-	prom.txBytesTotal.DeleteLabelValues(name)
-	prom.rxBytesTotal.DeleteLabelValues(name)
+func (prom *Prometheus) Clear(host string, name string) {
+	prom.cpuUsagePercent.DeleteLabelValues(host, name)
+	prom.cpuUserPercent.DeleteLabelValues(host, name)
+	prom.cpuSystemPercent.DeleteLabelValues(host, name)
+	prom.cpuThrottledRatio.DeleteLabelValues(host, name)
+	prom.cpuThrottledTime.delete(host, name)
+	prom.memoryUsagePercent.DeleteLabelValues(host, name)
+	prom.txBytesTotal.delete(host, name)
+	prom.rxBytesTotal.delete(host, name)
+	prom.paused.DeleteLabelValues(host, name)
+
+	prom.memoryRss.DeleteLabelValues(host, name)
+	prom.memoryCache.DeleteLabelValues(host, name)
+	prom.memorySwap.DeleteLabelValues(host, name)
+	prom.memoryWorkingSet.DeleteLabelValues(host, name)
+	prom.memoryLimit.DeleteLabelValues(host, name)
+
+	prom.pids.DeleteLabelValues(host, name)
+	prom.pidsLimit.DeleteLabelValues(host, name)
+
+	for _, status := range healthStatuses {
+		prom.health.DeleteLabelValues(host, name, status)
+	}
+	prom.restartCount.DeleteLabelValues(host, name)
+	prom.oomKilled.DeleteLabelValues(host, name)
+	prom.uptime.DeleteLabelValues(host, name)
+	prom.cpuLimit.DeleteLabelValues(host, name)
+	prom.memoryLimitConfigured.DeleteLabelValues(host, name)
+
+	prom.blkioReadBytes.delete(host, name)
+	prom.blkioWriteBytes.delete(host, name)
+	prom.blkioReadOps.delete(host, name)
+	prom.blkioWriteOps.delete(host, name)
+
+	prom.labelsLock.Lock()
+	devices := prom.devices[host+"/"+name]
+	interfaces := prom.interfaces[host+"/"+name]
+	info := prom.infos[host+"/"+name]
+	delete(prom.devices, host+"/"+name)
+	delete(prom.interfaces, host+"/"+name)
+	delete(prom.infos, host+"/"+name)
+	prom.labelsLock.Unlock()
+
+	if info != nil {
+		prom.info.DeleteLabelValues(info...)
+	}
+
+	prom.deleteDevices(host, name, devices)
+	prom.deleteInterfaces(host, name, interfaces)
+}
+
+// Deletes the series of the given block devices of the container.
+func (prom *Prometheus) deleteDevices(host string, name string, devices []string) {
+	for _, device := range devices {
+		prom.blkioDeviceReadBytes.delete(host, name, device)
+		prom.blkioDeviceWriteBytes.delete(host, name, device)
+		prom.blkioDeviceReadOps.delete(host, name, device)
+		prom.blkioDeviceWriteOps.delete(host, name, device)
+	}
 }
 
-func NewPrometheus(opts *PrometheusOpts) (*Prometheus, error) {
-	// hacky way of removing the default Go Collector.
-	prometheus.Unregister(prometheus.NewGoCollector())
+// Deletes the series of the given network interfaces of the container.
+func (prom *Prometheus) deleteInterfaces(host string, name string, interfaces []string) {
+	for _, iface := range interfaces {
+		prom.netRxBytes.delete(host, name, iface)
+		prom.netRxPackets.delete(host, name, iface)
+		prom.netRxErrors.delete(host, name, iface)
+		prom.netRxDropped.delete(host, name, iface)
+		prom.netTxBytes.delete(host, name, iface)
+		prom.netTxPackets.delete(host, name, iface)
+		prom.netTxErrors.delete(host, name, iface)
+		prom.netTxDropped.delete(host, name, iface)
+	}
+}
 
-	cpuUsagePercent := prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "cpu_usage_percent",
-			Help: "Current CPU usage percent.",
-		},
-		[]string{"container"},
-	)
+// Values of previous missing from current.
+func missing(previous []string, current []string) []string {
+	var gone []string
 
-	memoryUsagePercent := prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "memory_usage_percent",
-			Help: "Current memory usage percent.",
-		},
-		[]string{"container"},
-	)
+	for _, p := range previous {
+		found := false
+		for _, c := range current {
+			if p == c {
+				found = true
+				break
+			}
+		}
 
-	txBytesTotal := prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "tx_bytes",
-			Help: "TX Bytes Total.",
+		if !found {
+			gone = append(gone, p)
+		}
+	}
+
+	return gone
+}
+
+// Creates and registers a counter with one series per container, labeled by host and container,
+// plus the given extra labels.
+func newContainerCounter(name string, help string, labels ...string) *counterVec {
+	vec := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: name,
+			Help: help,
 		},
-		[]string{"container"},
+		append([]string{"host", "container"}, labels...),
 	)
 
-	rxBytesTotal := prometheus.NewGaugeVec(
+	prometheus.MustRegister(vec)
+
+	return &counterVec{
+		vec:  vec,
+		last: make(map[string]float64),
+		unit: 1,
+	}
+}
+
+// Creates and registers a counter of seconds, fed with totals in nanoseconds.
+func newContainerSecondsCounter(name string, help string, labels ...string) *counterVec {
+	c := newContainerCounter(name, help, labels...)
+	c.unit = 1e-9
+
+	return c
+}
+
+// Creates and registers a gauge with one series per container, labeled by host and container, plus
+// the given extra labels.
+func newContainerGauge(name string, help string, labels ...string) *prometheus.GaugeVec {
+	gauge := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "rx_bytes",
-			Help: "RX Bytes Total.",
+			Name: name,
+			Help: help,
 		},
-		[]string{"container"},
+		append([]string{"host", "container"}, labels...),
 	)
 
-	prometheus.MustRegister(cpuUsagePercent)
-	prometheus.MustRegister(memoryUsagePercent)
-	prometheus.MustRegister(txBytesTotal)
-	prometheus.MustRegister(rxBytesTotal)
+	prometheus.MustRegister(gauge)
+
+	return gauge
+}
+
+func NewPrometheus(opts *PrometheusOpts) (*Prometheus, error) {
+	// hacky way of removing the default Go Collector.
+	prometheus.Unregister(prometheus.NewGoCollector())
+
+	prom := &Prometheus{
+		cpuUsagePercent:    newContainerGauge("cpu_usage_percent", "Current CPU usage percent."),
+		cpuUserPercent:     newContainerGauge("cpu_user_percent", "Current CPU usage percent in user space."),
+		cpuSystemPercent:   newContainerGauge("cpu_system_percent", "Current CPU usage percent in the kernel."),
+		cpuThrottledRatio:  newContainerGauge("cpu_throttled_ratio", "Ratio of CFS periods in which the container was throttled."),
+		cpuThrottledTime:   newContainerSecondsCounter("cpu_throttled_seconds_total", "Time throttled Total, in seconds."),
+		memoryUsagePercent: newContainerGauge("memory_usage_percent", "Current memory usage percent."),
+		txBytesTotal:       newContainerCounter("tx_bytes_total", "TX Bytes Total."),
+		rxBytesTotal:       newContainerCounter("rx_bytes_total", "RX Bytes Total."),
+		paused:             newContainerGauge("paused", "Whether the container is paused (1) or not (0)."),
+
+		memoryRss:        newContainerGauge("memory_rss_bytes", "Anonymous memory, in bytes."),
+		memoryCache:      newContainerGauge("memory_cache_bytes", "Page cache memory, in bytes."),
+		memorySwap:       newContainerGauge("memory_swap_bytes", "Swap usage, in bytes."),
+		memoryWorkingSet: newContainerGauge("memory_working_set_bytes", "Memory usage without the inactive page cache, in bytes."),
+		memoryLimit:      newContainerGauge("memory_limit_bytes", "Memory limit, in bytes."),
+
+		pids:      newContainerGauge("pids", "Current number of processes and threads."),
+		pidsLimit: newContainerGauge("pids_limit", "Limit of processes and threads, 0 if unlimited."),
+
+		health:                newContainerGauge("health_status", "Whether the container has the health status (1) or not (0).", "status"),
+		restartCount:          newContainerGauge("restart_count", "Number of restarts done by the restart policy."),
+		oomKilled:             newContainerGauge("oom_killed", "Whether the last exit was because of running out of memory (1) or not (0)."),
+		uptime:                newContainerGauge("uptime_seconds", "Seconds since the container started."),
+		cpuLimit:              newContainerGauge("cpu_limit_cores", "Configured CPU limit, in cores, 0 if unlimited."),
+		memoryLimitConfigured: newContainerGauge("memory_limit_configured_bytes", "Configured memory limit, in bytes, 0 if unlimited."),
+
+		blkioReadBytes:  newContainerCounter("blkio_read_bytes_total", "Block I/O Bytes Read Total."),
+		blkioWriteBytes: newContainerCounter("blkio_write_bytes_total", "Block I/O Bytes Written Total."),
+		blkioReadOps:    newContainerCounter("blkio_read_ops_total", "Block I/O Read Operations Total."),
+		blkioWriteOps:   newContainerCounter("blkio_write_ops_total", "Block I/O Write Operations Total."),
+
+		blkioDeviceReadBytes: newContainerCounter("blkio_device_read_bytes_total",
+			"Block I/O Bytes Read Total, by device.", "device"),
+		blkioDeviceWriteBytes: newContainerCounter("blkio_device_write_bytes_total",
+			"Block I/O Bytes Written Total, by device.", "device"),
+		blkioDeviceReadOps: newContainerCounter("blkio_device_read_ops_total",
+			"Block I/O Read Operations Total, by device.", "device"),
+		blkioDeviceWriteOps: newContainerCounter("blkio_device_write_ops_total",
+			"Block I/O Write Operations Total, by device.", "device"),
+
+		netRxBytes:   newContainerCounter("network_rx_bytes_total", "RX Bytes Total, by interface.", "interface"),
+		netRxPackets: newContainerCounter("network_rx_packets_total", "RX Packets Total, by interface.", "interface"),
+		netRxErrors:  newContainerCounter("network_rx_errors_total", "RX Errors Total, by interface.", "interface"),
+		netRxDropped: newContainerCounter("network_rx_dropped_total", "RX Dropped Packets Total, by interface.", "interface"),
+		netTxBytes:   newContainerCounter("network_tx_bytes_total", "TX Bytes Total, by interface.", "interface"),
+		netTxPackets: newContainerCounter("network_tx_packets_total", "TX Packets Total, by interface.", "interface"),
+		netTxErrors:  newContainerCounter("network_tx_errors_total", "TX Errors Total, by interface.", "interface"),
+		netTxDropped: newContainerCounter("network_tx_dropped_total", "TX Dropped Packets Total, by interface.", "interface"),
+
+		info: newContainerGauge("container_info", "Identity metadata of the container, always 1.", infoLabels...),
+
+		devices:    make(map[string][]string),
+		interfaces: make(map[string][]string),
+		infos:      make(map[string][]string),
+	}
 
 	// set handler for default Prometheus collection path.
-	http.Handle("/metrics", promhttp.Handler())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 
 	// start HTTP Server.
-	go serve(opts.Address)
+	prom.server = newServer(opts.Address, mux)
+	if err := serve(prom.server); err != nil {
+		return nil, err
+	}
 
-	return &Prometheus{
-		cpuUsagePercent:    cpuUsagePercent,
-		memoryUsagePercent: memoryUsagePercent,
-		txBytesTotal:       txBytesTotal,
-		rxBytesTotal:       rxBytesTotal,
-	}, nil
+	return prom, nil
 }
 
 func (prom *Prometheus) Push(s *stats.Stats) error {
-	prom.cpuUsagePercent.WithLabelValues(s.Name).Set(s.CpuPercent)
-	prom.memoryUsagePercent.WithLabelValues(s.Name).Set(s.MemoryPercent)
-	prom.txBytesTotal.WithLabelValues(s.Name).Set(s.MemoryPercent)
-	prom.rxBytesTotal.WithLabelValues(s.Name).Set(s.MemoryPercent)
+	prom.pushInfo(s)
+
+	// a paused container has no CPU usage to report.
+	if s.Paused {
+		prom.paused.WithLabelValues(s.Host, s.Name).Set(1)
+		prom.cpuUsagePercent.DeleteLabelValues(s.Host, s.Name)
+		prom.cpuUserPercent.DeleteLabelValues(s.Host, s.Name)
+		prom.cpuSystemPercent.DeleteLabelValues(s.Host, s.Name)
+		prom.cpuThrottledRatio.DeleteLabelValues(s.Host, s.Name)
+	} else {
+		prom.paused.WithLabelValues(s.Host, s.Name).Set(0)
+		prom.cpuUsagePercent.WithLabelValues(s.Host, s.Name).Set(s.CpuPercent)
+		prom.cpuUserPercent.WithLabelValues(s.Host, s.Name).Set(s.CpuUserPercent)
+		prom.cpuSystemPercent.WithLabelValues(s.Host, s.Name).Set(s.CpuSystemPercent)
+		prom.cpuThrottledRatio.WithLabelValues(s.Host, s.Name).Set(s.CpuThrottledRatio)
+	}
+
+	prom.cpuThrottledTime.set(s.CpuThrottledTime, s.Host, s.Name)
+
+	prom.memoryUsagePercent.WithLabelValues(s.Host, s.Name).Set(s.MemoryPercent)
+	prom.memoryRss.WithLabelValues(s.Host, s.Name).Set(float64(s.MemoryRss))
+	prom.memoryCache.WithLabelValues(s.Host, s.Name).Set(float64(s.MemoryCache))
+	prom.memorySwap.WithLabelValues(s.Host, s.Name).Set(float64(s.MemorySwap))
+	prom.memoryWorkingSet.WithLabelValues(s.Host, s.Name).Set(float64(s.MemoryWorkingSet))
+	prom.memoryLimit.WithLabelValues(s.Host, s.Name).Set(float64(s.MemoryLimit))
+
+	prom.pids.WithLabelValues(s.Host, s.Name).Set(float64(s.Pids))
+	prom.pidsLimit.WithLabelValues(s.Host, s.Name).Set(float64(s.PidsLimit))
+
+	// one series per status, only for containers with a health check.
+	for _, status := range healthStatuses {
+		if s.Health == "" {
+			prom.health.DeleteLabelValues(s.Host, s.Name, status)
+		} else {
+			prom.health.WithLabelValues(s.Host, s.Name, status).Set(boolToFloat(s.Health == status))
+		}
+	}
+
+	prom.restartCount.WithLabelValues(s.Host, s.Name).Set(float64(s.RestartCount))
+	prom.oomKilled.WithLabelValues(s.Host, s.Name).Set(boolToFloat(s.OOMKilled))
+	prom.uptime.WithLabelValues(s.Host, s.Name).Set(s.Uptime)
+	prom.cpuLimit.WithLabelValues(s.Host, s.Name).Set(s.CpuLimit)
+	prom.memoryLimitConfigured.WithLabelValues(s.Host, s.Name).Set(float64(s.MemoryLimitConfigured))
+
+	prom.txBytesTotal.set(s.TxBytesTotal, s.Host, s.Name)
+	prom.rxBytesTotal.set(s.RxBytesTotal, s.Host, s.Name)
+
+	prom.blkioReadBytes.set(s.BlockReadBytes, s.Host, s.Name)
+	prom.blkioWriteBytes.set(s.BlockWriteBytes, s.Host, s.Name)
+	prom.blkioReadOps.set(s.BlockReadOps, s.Host, s.Name)
+	prom.blkioWriteOps.set(s.BlockWriteOps, s.Host, s.Name)
+
+	devices := make([]string, 0, len(s.BlockDevices))
+
+	for _, d := range s.BlockDevices {
+		prom.blkioDeviceReadBytes.set(d.ReadBytes, s.Host, s.Name, d.Device)
+		prom.blkioDeviceWriteBytes.set(d.WriteBytes, s.Host, s.Name, d.Device)
+		prom.blkioDeviceReadOps.set(d.ReadOps, s.Host, s.Name, d.Device)
+		prom.blkioDeviceWriteOps.set(d.WriteOps, s.Host, s.Name, d.Device)
+
+		devices = append(devices, d.Device)
+	}
+
+	prom.labelsLock.Lock()
+	previousDevices := prom.devices[s.Host+"/"+s.Name]
+	prom.devices[s.Host+"/"+s.Name] = devices
+	prom.labelsLock.Unlock()
+
+	// a device no longer reported would keep its last values as if they were current.
+	prom.deleteDevices(s.Host, s.Name, missing(previousDevices, devices))
+
+	interfaces := make([]string, 0, len(s.Networks))
+
+	for _, n := range s.Networks {
+		prom.netRxBytes.set(n.RxBytes, s.Host, s.Name, n.Interface)
+		prom.netRxPackets.set(n.RxPackets, s.Host, s.Name, n.Interface)
+		prom.netRxErrors.set(n.RxErrors, s.Host, s.Name, n.Interface)
+		prom.netRxDropped.set(n.RxDropped, s.Host, s.Name, n.Interface)
+		prom.netTxBytes.set(n.TxBytes, s.Host, s.Name, n.Interface)
+		prom.netTxPackets.set(n.TxPackets, s.Host, s.Name, n.Interface)
+		prom.netTxErrors.set(n.TxErrors, s.Host, s.Name, n.Interface)
+		prom.netTxDropped.set(n.TxDropped, s.Host, s.Name, n.Interface)
+
+		interfaces = append(interfaces, n.Interface)
+	}
+
+	prom.labelsLock.Lock()
+	previousInterfaces := prom.interfaces[s.Host+"/"+s.Name]
+	prom.interfaces[s.Host+"/"+s.Name] = interfaces
+	prom.labelsLock.Unlock()
+
+	// an interface of a network the container detached from, likewise.
+	prom.deleteInterfaces(s.Host, s.Name, missing(previousInterfaces, interfaces))
 
 	return nil
 }
 
-func (prom *Prometheus) Close() {
-	// TODO
+// Sets the info metric of the container, replacing the previous one if its identity changed.
+func (prom *Prometheus) pushInfo(s *stats.Stats) {
+	info := []string{s.Host, s.Name, s.ShortID, s.Image, s.ImageID, s.ComposeProject, s.ComposeService,
+		s.SwarmService, s.SwarmTask, s.K8sNamespace, s.K8sPod, s.K8sContainer}
+
+	prom.labelsLock.Lock()
+	previous := prom.infos[s.Host+"/"+s.Name]
+	prom.infos[s.Host+"/"+s.Name] = info
+	prom.labelsLock.Unlock()
+
+	if previous != nil && strings.Join(previous, "/") != strings.Join(info, "/") {
+		prom.info.DeleteLabelValues(previous...)
+	}
+
+	prom.info.WithLabelValues(info...).Set(1)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
-func serve(address string) {
-	log.Fatal(http.ListenAndServe(address, nil))
+func (prom *Prometheus) Close(ctx context.Context) error {
+	return prom.server.Shutdown(ctx)
 }
 
 func CreatePrometheusOpts() *PrometheusOpts {
@@ -1,22 +1,33 @@
 package common
 
 import (
+	"context"
 	"net/http"
 	"encoding/json"
 	"flag"
+	"sync"
 
-	"github.com/prometheus/common/log"
 	"github.com/mijara/statspout/repo"
 	"github.com/mijara/statspout/stats"
 )
 
+const (
+	REST_EVENTS_SIZE = 100 // number of events kept, the oldest are discarded.
+)
+
 type Rest struct {
-	registry map[string]stats.Stats
+	registry  map[string]stats.Stats
+	events    []stats.Event
+	processes map[string]stats.Processes
+	lock      sync.Mutex
+	server    *http.Server
 }
 
 type RestOpts struct {
-	Address string
-	Path    string
+	Address       string
+	Path          string
+	EventsPath    string
+	ProcessesPath string
 }
 
 // instance of this repository, due to the handler callback limitations.
@@ -31,11 +42,19 @@ func (*Rest) Create(v interface{}) (repo.Interface, error) {
 }
 
 func NewRest(opts *RestOpts) (*Rest, error) {
-	http.HandleFunc(checkAndFixPrefixSlash(opts.Path), handler)
+	mux := http.NewServeMux()
+	mux.HandleFunc(checkAndFixPrefixSlash(opts.Path), handler)
+	mux.HandleFunc(checkAndFixPrefixSlash(opts.EventsPath), eventsHandler)
+	mux.HandleFunc(checkAndFixPrefixSlash(opts.ProcessesPath), processesHandler)
 
 	rest.registry = map[string]stats.Stats{}
+	rest.events = []stats.Event{}
+	rest.processes = map[string]stats.Processes{}
+	rest.server = newServer(opts.Address, mux)
 
-	go serveRest(opts.Address)
+	if err := serve(rest.server); err != nil {
+		return nil, err
+	}
 
 	return &rest, nil
 }
@@ -47,7 +66,35 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(rest.asListOfValues())
 }
 
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	rest.lock.Lock()
+	defer rest.lock.Unlock()
+
+	json.NewEncoder(w).Encode(rest.events)
+}
+
+func processesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	rest.lock.Lock()
+	defer rest.lock.Unlock()
+
+	list := []stats.Processes{}
+	for _, value := range rest.processes {
+		list = append(list, value)
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
 func (rest *Rest) asListOfValues() []stats.Stats {
+	rest.lock.Lock()
+	defer rest.lock.Unlock()
+
 	var list []stats.Stats
 
 	for _, value := range rest.registry {
@@ -58,15 +105,43 @@ func (rest *Rest) asListOfValues() []stats.Stats {
 }
 
 func (rest *Rest) Push(s *stats.Stats) error {
-	rest.registry[s.Name] = *s
+	rest.lock.Lock()
+	defer rest.lock.Unlock()
+
+	rest.registry[s.Host+"/"+s.Name] = *s
 	return nil
 }
 
-func (rest *Rest) Close() {
+func (rest *Rest) PushEvent(e *stats.Event) error {
+	rest.lock.Lock()
+	defer rest.lock.Unlock()
+
+	rest.events = append(rest.events, *e)
+	if len(rest.events) > REST_EVENTS_SIZE {
+		rest.events = rest.events[len(rest.events)-REST_EVENTS_SIZE:]
+	}
+
+	return nil
 }
 
-func (rest *Rest) Clear(name string) {
-	delete(rest.registry, name)
+func (rest *Rest) PushProcesses(p *stats.Processes) error {
+	rest.lock.Lock()
+	defer rest.lock.Unlock()
+
+	rest.processes[p.Host+"/"+p.Name] = *p
+	return nil
+}
+
+func (rest *Rest) Close(ctx context.Context) error {
+	return rest.server.Shutdown(ctx)
+}
+
+func (rest *Rest) Clear(host string, name string) {
+	rest.lock.Lock()
+	defer rest.lock.Unlock()
+
+	delete(rest.registry, host+"/"+name)
+	delete(rest.processes, host+"/"+name)
 }
 
 func CreateRestOpts() *RestOpts {
@@ -82,11 +157,17 @@ func CreateRestOpts() *RestOpts {
 		"/stats",
 		"Path on which data is served.")
 
-	return o
-}
+	flag.StringVar(&o.EventsPath,
+		"rest.events.path",
+		"/events",
+		"Path on which the last container events are served.")
 
-func serveRest(address string) {
-	log.Fatal(http.ListenAndServe(address, nil))
+	flag.StringVar(&o.ProcessesPath,
+		"rest.processes.path",
+		"/processes",
+		"Path on which the last process listing of each container is served.")
+
+	return o
 }
 
 func checkAndFixPrefixSlash(path string) string {
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/mijara/statspout/repo"
@@ -18,7 +19,7 @@ func (*Stdout) Create(v interface{}) (repo.Interface, error) {
 	return NewStdout(), nil
 }
 
-func (*Stdout) Clear(name string) {
+func (*Stdout) Clear(host string, name string) {
 }
 
 func NewStdout() *Stdout {
@@ -30,6 +31,11 @@ func (stdout *Stdout) Push(s *stats.Stats) error {
 	return nil
 }
 
-func (stdout *Stdout) Close() {
+func (stdout *Stdout) PushEvent(e *stats.Event) error {
+	fmt.Println(e)
+	return nil
+}
 
+func (stdout *Stdout) Close(ctx context.Context) error {
+	return nil
 }
@@ -0,0 +1,197 @@
+package opts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DOCKER_DEFAULT_PORT     = "2375" // port of a tcp:// host without one.
+	DOCKER_DEFAULT_TLS_PORT = "2376" // port of a tcp:// host without one, using TLS.
+)
+
+// Docker CLI context metadata, as stored in ~/.docker/contexts/meta/<id>/meta.json.
+type dockerContext struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host          string `json:"Host"`
+		SkipTLSVerify bool   `json:"SkipTLSVerify"`
+	} `json:"Endpoints"`
+}
+
+// Docker CLI configuration, only what is needed to know the current context.
+type dockerConfig struct {
+	CurrentContext string `json:"currentContext"`
+}
+
+// Fills the mode options from the environment, the same way the docker CLI selects the daemon, only
+// if no mode options were given in the command line. The precedence is: the context flag, DOCKER_HOST,
+// DOCKER_CONTEXT and finally the current context of the Docker CLI configuration.
+func resolveMode() error {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "mode", "socket.path", "http.address", "tls.address", "npipe.path":
+			explicit = true
+		}
+	})
+
+	if explicit {
+		return nil
+	}
+
+	if GetOpts().Mode.Context != "" {
+		return resolveContext(GetOpts().Mode.Context)
+	}
+
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		secure := os.Getenv("DOCKER_TLS_VERIFY") != "" || os.Getenv("DOCKER_TLS") != ""
+		return resolveHost(&GetOpts().Mode, host, secure)
+	}
+
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		name = currentContext()
+	}
+
+	if name != "" {
+		return resolveContext(name)
+	}
+
+	return nil
+}
+
+// Fills the given mode options from a Docker host URL: unix://, tcp:// or npipe://.
+func resolveHost(m *mode, host string, secure bool) error {
+	u, err := url.Parse(host)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		m.Name = "socket"
+		m.Socket.Path = u.Path
+	case "tcp", "http", "https":
+		secure = secure || u.Scheme == "https"
+
+		// the port is optional, the docker CLI uses the default one.
+		address := u.Host
+		if u.Port() == "" {
+			port := DOCKER_DEFAULT_PORT
+			if secure {
+				port = DOCKER_DEFAULT_TLS_PORT
+			}
+			address = net.JoinHostPort(u.Hostname(), port)
+		}
+
+		if secure {
+			m.Name = "tls"
+			m.TLS.Address = address
+		} else {
+			m.Name = "http"
+			m.HTTP.Address = address
+		}
+	case "npipe":
+		// npipe:////./pipe/docker_engine is the pipe \\.\pipe\docker_engine.
+		m.Name = "npipe"
+		m.NPipe.Path = strings.Replace(u.Path, "/", `\`, -1)
+	default:
+		return errors.New("Unsupported Docker host: " + host)
+	}
+
+	return nil
+}
+
+// Fills the mode options from a named Docker CLI context.
+func resolveContext(name string) error {
+	// the default context is just the environment or the default socket.
+	if name == "default" {
+		if host := os.Getenv("DOCKER_HOST"); host != "" {
+			return resolveHost(&GetOpts().Mode, host, os.Getenv("DOCKER_TLS_VERIFY") != "")
+		}
+
+		return nil
+	}
+
+	// contexts are stored by the digest of their name.
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+
+	body, err := ioutil.ReadFile(filepath.Join(dockerConfigDir(), "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return errors.New("Unknown Docker context: " + name)
+	}
+
+	ctx := dockerContext{}
+	if err := json.Unmarshal(body, &ctx); err != nil {
+		return err
+	}
+
+	endpoint, ok := ctx.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return errors.New("Docker context has no docker endpoint: " + name)
+	}
+
+	// a context with TLS material is reached using TLS.
+	tlsDir := filepath.Join(dockerConfigDir(), "contexts", "tls", id, "docker")
+	secure := false
+	if _, err := os.Stat(tlsDir); err == nil {
+		secure = true
+
+		m := &GetOpts().Mode
+		m.TLS.CA = existingFile(filepath.Join(tlsDir, "ca.pem"))
+		m.TLS.Cert = existingFile(filepath.Join(tlsDir, "cert.pem"))
+		m.TLS.Key = existingFile(filepath.Join(tlsDir, "key.pem"))
+		m.TLS.Verify = !endpoint.SkipTLSVerify
+	}
+
+	return resolveHost(&GetOpts().Mode, endpoint.Host, secure)
+}
+
+// Returns the current context of the Docker CLI configuration, or an empty string.
+func currentContext() string {
+	body, err := ioutil.ReadFile(filepath.Join(dockerConfigDir(), "config.json"))
+	if err != nil {
+		return ""
+	}
+
+	cfg := dockerConfig{}
+	if err := json.Unmarshal(body, &cfg); err != nil {
+		return ""
+	}
+
+	return cfg.CurrentContext
+}
+
+// Returns the Docker CLI configuration directory, DOCKER_CONFIG or ~/.docker.
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".docker"
+	}
+
+	return filepath.Join(home, ".docker")
+}
+
+// Returns the path if the file exists, or an empty string.
+func existingFile(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+
+	return path
+}
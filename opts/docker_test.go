@@ -0,0 +1,154 @@
+package opts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		secure  bool
+		name    string
+		address string
+	}{
+		{"unix:///var/run/docker.sock", false, "socket", "/var/run/docker.sock"},
+		{"tcp://10.0.0.2:2375", false, "http", "10.0.0.2:2375"},
+		{"tcp://10.0.0.2", false, "http", "10.0.0.2:2375"},
+		{"tcp://10.0.0.2", true, "tls", "10.0.0.2:2376"},
+		{"tcp://10.0.0.2:4243", true, "tls", "10.0.0.2:4243"},
+		{"https://docker.local", false, "tls", "docker.local:2376"},
+		{"tcp://[::1]", false, "http", "[::1]:2375"},
+		{"npipe:////./pipe/docker_engine", false, "npipe", `\\.\pipe\docker_engine`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			m := &mode{}
+			if err := resolveHost(m, tt.host, tt.secure); err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Name != tt.name {
+				t.Errorf("mode = %s, want %s", m.Name, tt.name)
+			}
+
+			address := map[string]string{
+				"socket": m.Socket.Path,
+				"http":   m.HTTP.Address,
+				"tls":    m.TLS.Address,
+				"npipe":  m.NPipe.Path,
+			}[m.Name]
+
+			if address != tt.address {
+				t.Errorf("address = %s, want %s", address, tt.address)
+			}
+		})
+	}
+
+	if err := resolveHost(&mode{}, "ssh://user@host", false); err == nil {
+		t.Error("resolveHost() of an ssh:// host should fail")
+	}
+}
+
+// Writes a Docker CLI context on the given configuration directory, with TLS material if asked to.
+func writeContext(t *testing.T, dir string, name string, host string, tls bool) {
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+
+	meta := filepath.Join(dir, "contexts", "meta", id)
+	if err := os.MkdirAll(meta, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"Name":"` + name + `","Endpoints":{"docker":{"Host":"` + host + `","SkipTLSVerify":false}}}`
+	if err := ioutil.WriteFile(filepath.Join(meta, "meta.json"), []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !tls {
+		return
+	}
+
+	certs := filepath.Join(dir, "contexts", "tls", id, "docker")
+	if err := os.MkdirAll(certs, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{"ca.pem", "cert.pem", "key.pem"} {
+		if err := ioutil.WriteFile(filepath.Join(certs, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+
+	writeContext(t, dir, "remote", "tcp://10.0.0.2", false)
+	writeContext(t, dir, "secure", "tcp://10.0.0.3:2376", true)
+	writeContext(t, dir, "local", "unix:///run/user/1000/docker.sock", false)
+
+	tests := []struct {
+		context string
+		name    string
+		address string
+		tls     bool
+	}{
+		{"remote", "http", "10.0.0.2:2375", false},
+		{"secure", "tls", "10.0.0.3:2376", true},
+		{"local", "socket", "/run/user/1000/docker.sock", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.context, func(t *testing.T) {
+			GetOpts().Mode = mode{}
+
+			if err := resolveContext(tt.context); err != nil {
+				t.Fatal(err)
+			}
+
+			m := GetOpts().Mode
+			if m.Name != tt.name {
+				t.Errorf("mode = %s, want %s", m.Name, tt.name)
+			}
+
+			address := map[string]string{
+				"socket": m.Socket.Path,
+				"http":   m.HTTP.Address,
+				"tls":    m.TLS.Address,
+			}[m.Name]
+
+			if address != tt.address {
+				t.Errorf("address = %s, want %s", address, tt.address)
+			}
+
+			// the TLS material of the context is used as the TLS options.
+			if (m.TLS.CA != "") != tt.tls || (m.TLS.Cert != "") != tt.tls || (m.TLS.Key != "") != tt.tls {
+				t.Errorf("tls options = %+v, want them set: %v", m.TLS, tt.tls)
+			}
+		})
+	}
+
+	GetOpts().Mode = mode{}
+
+	if err := resolveContext("missing"); err == nil {
+		t.Error("resolveContext() of a missing context should fail")
+	}
+
+	// the default context is the default socket, without DOCKER_HOST.
+	if err := resolveContext("default"); err != nil || GetOpts().Mode.Name != "" {
+		t.Errorf("resolveContext(default) = %v, mode %q, want no error and no mode", err, GetOpts().Mode.Name)
+	}
+}
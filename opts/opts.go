@@ -1,9 +1,15 @@
 package opts
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
+	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/mijara/statspout/backend"
 	"github.com/mijara/statspout/common"
@@ -16,20 +22,39 @@ type options struct {
 	Repository string   // Which repository to use.
 	Daemons    int      // Number of daemons to handle requests.
 	Ignore     []string // Container names to ignore, as an array.
+	Collect    string   // How stats are collected: poll or stream.
 
-	ignoreBuff string // Container names to ignore, separated by comma.
+	BlkioDevices bool   // Whether block I/O stats include the per-device breakdown.
+	CpuPercent   string // How CPU percentages are scaled: core or host.
 
-	Mode struct {
-		Name string // Client mode name
+	InspectInterval int // Seconds between each refresh of the inspect data, 0 to disable it.
 
-		Socket struct {
-			Path string // Unix socket to connect Docker
-		}
+	TopInterval int // Seconds between each process listing, 0 to disable it.
+	TopCount    int // Number of processes listed, by CPU usage.
 
-		HTTP struct {
-			Address string // Docker API address
-		}
-	}
+	ShutdownTimeout int // Seconds to wait for queries and repositories to finish when stopping.
+
+	QueueSize    int    // Maximum number of pushes waiting for each repository.
+	QueueBatch   int    // Number of pushes done at once.
+	QueueFlush   int    // Seconds a push waits for its batch to fill.
+	QueueRetries int    // Times a failed push is retried.
+	QueueDrop    string // Which push is dropped when a queue is full: oldest or newest.
+	DeadLetter   string // Directory where the stats failing every retry are written, empty to disable it.
+
+	SpoolDir  string // Directory where failed pushes are kept until replayed, empty to disable it.
+	SpoolSize int    // Maximum megabytes spooled for each repository.
+	SpoolAge  int    // Maximum hours a push is kept on the spool.
+
+	HealthTimeout  int               // Seconds to wait for the repositories to be ready when starting, 0 to not wait.
+	HealthInterval int               // Seconds between each health check of the repositories, 0 to disable it.
+	Health         common.HealthOpts // Health endpoint options.
+
+	ignoreBuff string // Container names to ignore, separated by comma.
+
+	Mode mode // Client mode options.
+
+	Hosts     []string // Docker host URLs to monitor, optionally named as name=url.
+	hostsBuff string   // Docker host URLs to monitor, separated by comma.
 
 	Influx     common.InfluxOpts     // Influx specific options
 	Mongo      common.MongoOpts      // Mongo specific options.
@@ -37,6 +62,33 @@ type options struct {
 	Prometheus common.PrometheusOpts // Prometheus specific options.
 }
 
+// Options to create a client for a single Docker host.
+type mode struct {
+	Name string // Client mode name
+
+	Socket struct {
+		Path string // Unix socket to connect Docker
+	}
+
+	HTTP struct {
+		Address string // Docker API address
+	}
+
+	TLS struct {
+		Address string // Docker API address
+		CA      string // CA certificate path
+		Cert    string // Client certificate path
+		Key     string // Client key path
+		Verify  bool   // Verify the server certificate against the CA
+	}
+
+	NPipe struct {
+		Path string // Windows named pipe to connect Docker
+	}
+
+	Context string // Docker CLI context to resolve the mode from
+}
+
 // Single instance of this package.
 var i *options
 
@@ -61,7 +113,107 @@ func GetOpts() *options {
 	flag.StringVar(&i.Repository,
 		"repository",
 		"stdout",
-		"One of: stdout, mongodb, prometheus, influxdb, rest.")
+		"One of: stdout, mongodb, prometheus, influxdb, rest. Many of them can be given, separated by comma.")
+
+	flag.IntVar(&i.ShutdownTimeout,
+		"shutdown.timeout",
+		10,
+		"Seconds to wait for queries in progress and repositories to finish when stopping.")
+
+	flag.IntVar(&i.QueueSize,
+		"queue.size",
+		repo.DefaultQueueOptions.Size,
+		"Maximum number of pushes waiting for each repository.")
+
+	flag.IntVar(&i.QueueBatch,
+		"queue.batch",
+		repo.DefaultQueueOptions.Batch,
+		"Number of pushes done at once.")
+
+	flag.IntVar(&i.QueueFlush,
+		"queue.flush",
+		int(repo.DefaultQueueOptions.Flush/time.Second),
+		"Seconds a push waits for its batch to fill.")
+
+	flag.IntVar(&i.QueueRetries,
+		"queue.retries",
+		repo.DefaultQueueOptions.Retries,
+		"Times a failed push is retried, with exponential backoff, before giving up.")
+
+	flag.StringVar(&i.QueueDrop,
+		"queue.drop",
+		"oldest",
+		"Which push is dropped when a queue is full: oldest or newest.")
+
+	flag.StringVar(&i.DeadLetter,
+		"queue.deadletter",
+		"",
+		"Directory where the stats of pushes failing every retry are written, as JSON lines on a file for each repository.")
+
+	flag.StringVar(&i.SpoolDir,
+		"spool.dir",
+		"",
+		"Directory where pushes of stats failing every retry are kept, to replay them once the repository recovers. Events and process listings are not spooled.")
+
+	flag.IntVar(&i.SpoolSize,
+		"spool.size",
+		100,
+		"Maximum megabytes spooled for each repository, the oldest pushes are discarded beyond it.")
+
+	flag.IntVar(&i.SpoolAge,
+		"spool.age",
+		24,
+		"Maximum hours a push is kept on the spool, older pushes are discarded.")
+
+	flag.IntVar(&i.HealthTimeout,
+		"health.timeout",
+		0,
+		"Seconds to wait for the repositories to be reachable when starting, 0 to not wait.")
+
+	flag.IntVar(&i.HealthInterval,
+		"health.interval",
+		30,
+		"Seconds between each health check of the repositories, 0 to disable it.")
+
+	flag.StringVar(&i.Health.Address,
+		"health.address",
+		"",
+		"Address on which the health endpoint is published, disabled if empty.")
+
+	flag.StringVar(&i.Health.Path,
+		"health.path",
+		"/health",
+		"Path of the health endpoint.")
+
+	flag.StringVar(&i.Collect,
+		"collect",
+		"poll",
+		"How stats are collected: poll (one request per query) or stream (one stream per container).")
+
+	flag.BoolVar(&i.BlkioDevices,
+		"blkio.devices",
+		false,
+		"Include the per-device breakdown of block I/O stats.")
+
+	flag.StringVar(&i.CpuPercent,
+		"cpu.percent",
+		"core",
+		"How CPU percentages are scaled: core (100% per core, up to N*100%) or host (100% is the whole machine).")
+
+	flag.IntVar(&i.InspectInterval,
+		"inspect.interval",
+		30,
+		"Seconds between each refresh of the health, restarts and limits of each container, 0 to disable it.")
+
+	flag.IntVar(&i.TopInterval,
+		"top.interval",
+		0,
+		"Seconds between each listing of the top processes of each container, 0 to disable it.")
+
+	flag.IntVar(&i.TopCount,
+		"top.count",
+		5,
+		"Number of processes in each listing, the ones using more CPU.")
 
 	flag.StringVar(&i.ignoreBuff,
 		"ignore",
@@ -71,7 +223,18 @@ func GetOpts() *options {
 	flag.StringVar(&i.Mode.Name,
 		"mode",
 		"socket",
-		"Mode to create the client: socket, http, tls.")
+		"Mode to create the client: socket, http, tls, npipe. "+
+			"Resolved from DOCKER_HOST or the Docker CLI context if no mode options are given.")
+
+	flag.StringVar(&i.hostsBuff,
+		"hosts",
+		"",
+		"Docker host URLs to monitor, separated by comma. Each one can be named as name=url.")
+
+	flag.StringVar(&i.Mode.Context,
+		"context",
+		"",
+		"Docker CLI context to connect to, used when no mode options are given.")
 
 	flag.StringVar(&i.Mode.Socket.Path,
 		"socket.path",
@@ -83,6 +246,36 @@ func GetOpts() *options {
 		"localhost:4243",
 		"Docker API Address.")
 
+	flag.StringVar(&i.Mode.TLS.Address,
+		"tls.address",
+		"localhost:2376",
+		"Docker API Address, secured with TLS.")
+
+	flag.StringVar(&i.Mode.TLS.CA,
+		"tls.ca",
+		"",
+		"CA certificate path. Defaults to ca.pem in DOCKER_CERT_PATH or ~/.docker.")
+
+	flag.StringVar(&i.Mode.TLS.Cert,
+		"tls.cert",
+		"",
+		"Client certificate path. Defaults to cert.pem in DOCKER_CERT_PATH or ~/.docker.")
+
+	flag.StringVar(&i.Mode.TLS.Key,
+		"tls.key",
+		"",
+		"Client key path. Defaults to key.pem in DOCKER_CERT_PATH or ~/.docker.")
+
+	flag.BoolVar(&i.Mode.TLS.Verify,
+		"tls.verify",
+		os.Getenv("DOCKER_TLS_VERIFY") != "",
+		"Verify the server certificate. Defaults to true if DOCKER_TLS_VERIFY is set.")
+
+	flag.StringVar(&i.Mode.NPipe.Path,
+		"npipe.path",
+		`\\.\pipe\docker_engine`,
+		"Windows named pipe to connect to Docker.")
+
 	return i
 }
 
@@ -97,27 +290,255 @@ func (*options) Parse() {
 			i.Ignore = append(i.Ignore, name)
 		}
 	}
+
+	hosts := strings.Split(i.hostsBuff, ",")
+	i.Hosts = make([]string, 0)
+
+	for _, host := range hosts {
+		if host != "" {
+			i.Hosts = append(i.Hosts, host)
+		}
+	}
 }
 
-// Creates the repository from the options given by the client.
+// Creates the repository given by the flags, behind its own push queue. When many are given, they're
+// combined on a composite repository which pushes to all of them.
 func CreateRepositoryFromFlags(cfg *Config) (repo.Interface, error) {
-	for name, b := range cfg.Repositories {
-		if name == GetOpts().Repository {
-			return b.Repository.Create(b.Options)
+	options, err := queueOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	names := strings.Split(GetOpts().Repository, ",")
+
+	if len(names) == 1 {
+		return createRepository(cfg, names[0], options)
+	}
+
+	var members []repo.Interface
+	given := make(map[string]bool)
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if given[name] {
+			return nil, errors.New("Repository given twice: " + name)
 		}
+		given[name] = true
+
+		r, err := createRepository(cfg, name, options)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, r)
+	}
+
+	return repo.NewComposite(members...), nil
+}
+
+// Creates the named repository, behind a push queue.
+func createRepository(cfg *Config, name string, options repo.QueueOptions) (repo.Interface, error) {
+	b, ok := cfg.Repositories[name]
+	if !ok {
+		return nil, errors.New("Unknown repository: " + name)
+	}
+
+	// for instance, two repositories listening on the same address.
+	r, err := b.Repository.Create(b.Options)
+	if err != nil {
+		return nil, errors.New("Cannot create repository " + name + ": " + err.Error())
+	}
+
+	// each repository has its own dead letter file, named after it.
+	if GetOpts().DeadLetter != "" {
+		options.DeadLetter, err = repo.OpenDeadLetter(filepath.Join(GetOpts().DeadLetter, name+".jsonl"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// each repository has its own spool, on a directory named after it.
+	if GetOpts().SpoolDir != "" {
+		options.Spool, err = repo.OpenSpool(filepath.Join(GetOpts().SpoolDir, name),
+			int64(GetOpts().SpoolSize)*1024*1024, time.Duration(GetOpts().SpoolAge)*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return repo.NewQueue(r, options), nil
+}
+
+// Options of the push queues, given by the flags.
+func queueOptions() (repo.QueueOptions, error) {
+	options := repo.QueueOptions{
+		Size:    GetOpts().QueueSize,
+		Batch:   GetOpts().QueueBatch,
+		Flush:   time.Duration(GetOpts().QueueFlush) * time.Second,
+		Retries: GetOpts().QueueRetries,
+	}
+
+	if options.Size < 1 || options.Batch < 1 || options.Flush < time.Second || options.Retries < 0 {
+		return options, errors.New("Queue size, batch and flush cannot be less than 1, nor retries less than 0.")
+	}
+
+	switch GetOpts().QueueDrop {
+	case "oldest":
+		options.DropOldest = true
+	case "newest":
+		options.DropOldest = false
+	default:
+		return options, errors.New("Unknown queue drop policy: " + GetOpts().QueueDrop)
+	}
+
+	if GetOpts().SpoolDir != "" && (GetOpts().SpoolSize < 1 || GetOpts().SpoolAge < 1) {
+		return options, errors.New("Spool size and age cannot be less than 1.")
+	}
+
+	return options, nil
+}
+
+// Creates the clients from the options given by the client, one for each Docker host. The clients
+// stop working once the given context is done.
+func CreateClientsFromFlags(ctx context.Context, repo repo.Interface) ([]*backend.Client, error) {
+	options := backend.Options{
+		Daemons:      GetOpts().Daemons,
+		Interval:     time.Duration(GetOpts().Interval) * time.Second,
+		BlkioDevices: GetOpts().BlkioDevices,
+		TopCount:     GetOpts().TopCount,
+	}
+
+	switch GetOpts().Collect {
+	case "poll":
+		options.Stream = false
+	case "stream":
+		options.Stream = true
+	default:
+		return nil, errors.New("Unknown collect method: " + GetOpts().Collect)
+	}
+
+	switch GetOpts().CpuPercent {
+	case "core":
+		options.CpuNormalized = false
+	case "host":
+		options.CpuNormalized = true
+	default:
+		return nil, errors.New("Unknown CPU percent scale: " + GetOpts().CpuPercent)
+	}
+
+	// a single host, given by the mode options or the environment.
+	if len(GetOpts().Hosts) == 0 {
+		// fill the mode from the environment, if not given.
+		if err := resolveMode(); err != nil {
+			return nil, err
+		}
+
+		client, err := createClient(ctx, repo, &GetOpts().Mode, "", options)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*backend.Client{client}, nil
+	}
+
+	clients := make([]*backend.Client, 0, len(GetOpts().Hosts))
+
+	for _, host := range GetOpts().Hosts {
+		// hosts may be named as name=url.
+		name := ""
+		if parts := strings.SplitN(host, "=", 2); len(parts) == 2 {
+			name, host = parts[0], parts[1]
+		}
+
+		// each host shares the mode options (like TLS certificates), but not the address.
+		m := GetOpts().Mode
+		if err := resolveHost(&m, host, m.Name == "tls"); err != nil {
+			return nil, err
+		}
+
+		client, err := createClient(ctx, repo, &m, name, options)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
 	}
 
-	return nil, errors.New("Unknown repository: " + i.Repository)
+	return clients, nil
 }
 
-// Creates the client from the options given by the client.
-func CreateClientFromFlags(repo repo.Interface) (*backend.Client, error) {
-	switch GetOpts().Mode.Name {
+// Creates a client for the given mode, name identifies the host in the stats and defaults
+// to the address of the host (or the local hostname for sockets and pipes).
+func createClient(ctx context.Context, repo repo.Interface, m *mode, name string,
+	options backend.Options) (*backend.Client, error) {
+	var endpoint backend.Endpoint
+
+	switch m.Name {
 	case "socket":
-		return backend.New(repo, false, GetOpts().Mode.Socket.Path, GetOpts().Daemons)
+		endpoint = backend.NewSocketEndpoint(m.Socket.Path)
 	case "http":
-		return backend.New(repo, true, GetOpts().Mode.HTTP.Address, GetOpts().Daemons)
+		endpoint = backend.NewHTTPEndpoint(m.HTTP.Address)
+	case "tls":
+		config, err := createTLSConfig(m)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoint = backend.NewTLSEndpoint(m.TLS.Address, config)
+	case "npipe":
+		endpoint = backend.NewNamedPipeEndpoint(m.NPipe.Path)
+	default:
+		return nil, errors.New("Unknown mode: " + m.Name)
+	}
+
+	if name == "" {
+		name = hostName(endpoint)
+	}
+
+	return backend.New(ctx, repo, name, endpoint, options)
+}
+
+// Returns the default identifier of the host behind the given endpoint.
+func hostName(endpoint backend.Endpoint) string {
+	if endpoint.Network == "tcp" {
+		if host, _, err := net.SplitHostPort(endpoint.Address); err == nil {
+			return host
+		}
+
+		return endpoint.Address
+	}
+
+	// local socket or pipe, so this is the same host.
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+
+	return name
+}
+
+// Creates the TLS configuration from the given mode, certificates not given are looked up
+// in DOCKER_CERT_PATH (or ~/.docker), the same way the docker CLI does.
+func createTLSConfig(m *mode) (*tls.Config, error) {
+	o := m.TLS
+
+	return backend.NewTLSConfig(
+		certFile(o.CA, "ca.pem"),
+		certFile(o.Cert, "cert.pem"),
+		certFile(o.Key, "key.pem"),
+		o.Verify)
+}
+
+// Returns the given path, or the default one inside the cert path if it was not given and exists.
+func certFile(path string, name string) string {
+	if path != "" {
+		return path
+	}
+
+	dir := os.Getenv("DOCKER_CERT_PATH")
+	if dir == "" {
+		dir = dockerConfigDir()
 	}
 
-	return nil, errors.New("Unknown mode: " + GetOpts().Mode.Name)
+	return existingFile(filepath.Join(dir, name))
 }
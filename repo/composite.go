@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/stats"
+)
+
+// Operation done on a single member of a composite repository.
+type operation func(r Interface) error
+
+// Repository which fans out to many repositories at once. Members are expected not to block, like
+// queues do, so a slow or broken member doesn't hold the others, nor the caller.
+type Composite struct {
+	members []Interface
+}
+
+// Creates a composite repository of the given repositories.
+func NewComposite(repos ...Interface) *Composite {
+	return &Composite{members: repos}
+}
+
+// Creates a composite repository, v must be the slice of member repositories.
+func (*Composite) Create(v interface{}) (Interface, error) {
+	repos, ok := v.([]Interface)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Composite repository needs its members, got %T", v))
+	}
+
+	return NewComposite(repos...), nil
+}
+
+// Names of the members, separated by comma.
+func (composite *Composite) Name() string {
+	names := make([]string, 0, len(composite.members))
+	for _, r := range composite.members {
+		names = append(names, r.Name())
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (composite *Composite) Push(s *stats.Stats) error {
+	composite.each(func(r Interface) error {
+		return r.Push(s)
+	})
+
+	return nil
+}
+
+// Pushes the stats of the batch to every member, at once to the members capable of it.
+func (composite *Composite) PushBatch(batch []*stats.Stats) error {
+	composite.each(func(r Interface) error {
+		return PushBatch(r, batch)
+	})
+
+	return nil
+}
+
+// Pushes the event to the members capable of storing events.
+func (composite *Composite) PushEvent(e *stats.Event) error {
+	composite.each(func(r Interface) error {
+		if er, ok := r.(EventInterface); ok {
+			return er.PushEvent(e)
+		}
+		return nil
+	})
+
+	return nil
+}
+
+// Pushes the process listing to the members capable of storing them.
+func (composite *Composite) PushProcesses(p *stats.Processes) error {
+	composite.each(func(r Interface) error {
+		if pr, ok := r.(ProcessInterface); ok {
+			return pr.PushProcesses(p)
+		}
+		return nil
+	})
+
+	return nil
+}
+
+// Clears the container from every member.
+func (composite *Composite) Clear(host string, name string) {
+	composite.each(func(r Interface) error {
+		r.Clear(host, name)
+		return nil
+	})
+}
+
+// Members of the composite repository.
+func (composite *Composite) Members() []Interface {
+	return append([]Interface(nil), composite.members...)
+}
+
+// Checks every member capable of it is reachable.
+func (composite *Composite) Ping(ctx context.Context) error {
+	var failed []string
+
+	for _, r := range composite.members {
+		if err := Ping(ctx, r); err != nil {
+			failed = append(failed, r.Name()+": "+err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// Closes every member, until the given context is done.
+func (composite *Composite) Close(ctx context.Context) error {
+	errs := make(chan error, len(composite.members))
+
+	// members are closed at the same time, a slow one doesn't take the time of the others.
+	for _, r := range composite.members {
+		go func(r Interface) {
+			errs <- r.Close(ctx)
+		}(r)
+	}
+
+	var err error
+	for range composite.members {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Does the operation on every member, an error or a panic of one member doesn't stop the others.
+func (composite *Composite) each(op operation) {
+	for _, r := range composite.members {
+		if err := do(r, op); err != nil {
+			log.Error.Printf("Repository %s: %s", r.Name(), err.Error())
+		}
+	}
+}
+
+// Does a single operation on a member, a panic is reported as an error.
+func do(r Interface, op operation) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.New(fmt.Sprintf("Panic: %v", p))
+		}
+	}()
+
+	return op(r)
+}
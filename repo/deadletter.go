@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/stats"
+)
+
+// Dead letter file, where the stats discarded by a queue are appended as JSON lines, so they can be
+// inspected or pushed again by hand.
+type DeadLetter struct {
+	path string
+
+	lock sync.Mutex // guards file.
+	file *os.File   // nil once closed.
+}
+
+// Opens the dead letter file on the given path, creating it and its directory if needed.
+func OpenDeadLetter(path string) (*DeadLetter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetter{path: path, file: f}, nil
+}
+
+// Appends the stats to the file, they are lost if it's closed already.
+func (dl *DeadLetter) Write(s *stats.Stats) {
+	line, err := json.Marshal(s)
+	if err != nil {
+		log.Error.Printf("Cannot encode dead letter of %s: %s", s.Name, err.Error())
+		return
+	}
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.file == nil {
+		log.Error.Printf("Cannot write dead letter of %s to %s: file closed", s.Name, dl.path)
+		return
+	}
+
+	if _, err := dl.file.Write(append(line, '\n')); err != nil {
+		log.Error.Printf("Cannot write dead letter to %s: %s", dl.path, err.Error())
+	}
+}
+
+// Closes the file.
+func (dl *DeadLetter) Close() error {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.file == nil {
+		return nil
+	}
+
+	err := dl.file.Close()
+	dl.file = nil
+
+	return err
+}
@@ -0,0 +1,158 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+)
+
+const (
+	HEALTH_PING_TIMEOUT = 5 * time.Second // maximum time a repository takes to answer a ping.
+	HEALTH_WAIT_RETRY   = time.Second     // wait between checks while waiting for the repositories to be ready.
+)
+
+// Checks the repository is reachable if it's capable of it, otherwise it's always considered reachable.
+func Ping(ctx context.Context, r Interface) error {
+	hr, ok := r.(HealthInterface)
+	if !ok {
+		return nil
+	}
+
+	return hr.Ping(ctx)
+}
+
+// Status of a repository, as of its last check.
+type Status struct {
+	Name    string    `json:"name"`
+	Healthy bool      `json:"healthy"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// Health checks of the repositories, each member of a composite repository is checked on its own.
+type Health struct {
+	repos []Interface
+
+	lock     sync.RWMutex // guards statuses.
+	statuses []Status
+}
+
+// Creates the health checks of the given repository, every repository is considered healthy until
+// checked.
+func NewHealth(r Interface) *Health {
+	health := &Health{repos: []Interface{r}}
+
+	if composite, ok := r.(*Composite); ok {
+		health.repos = composite.Members()
+	}
+
+	for _, r := range health.repos {
+		health.statuses = append(health.statuses, Status{Name: r.Name(), Healthy: true})
+	}
+
+	return health
+}
+
+// Current status of every repository.
+func (health *Health) Statuses() []Status {
+	health.lock.RLock()
+	defer health.lock.RUnlock()
+
+	return append([]Status(nil), health.statuses...)
+}
+
+// Pings every repository at the same time, logging the ones which changed their status. Returns
+// whether every repository is healthy.
+func (health *Health) Check(ctx context.Context) bool {
+	statuses := make([]Status, len(health.repos))
+
+	var wg sync.WaitGroup
+	for i, r := range health.repos {
+		wg.Add(1)
+		go func(i int, r Interface) {
+			defer wg.Done()
+
+			pingCtx, cancel := context.WithTimeout(ctx, HEALTH_PING_TIMEOUT)
+			defer cancel()
+
+			statuses[i] = Status{Name: r.Name(), Healthy: true, Checked: time.Now()}
+			if err := Ping(pingCtx, r); err != nil {
+				statuses[i].Healthy = false
+				statuses[i].Error = err.Error()
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	health.lock.Lock()
+	defer health.lock.Unlock()
+
+	healthy := true
+	for i, status := range statuses {
+		if !status.Healthy {
+			healthy = false
+		}
+
+		if status.Healthy == health.statuses[i].Healthy {
+			continue
+		}
+
+		if status.Healthy {
+			log.Info.Printf("Repository %s is healthy again.", status.Name)
+		} else {
+			log.Warning.Printf("Repository %s is unhealthy: %s", status.Name, status.Error)
+		}
+	}
+
+	health.statuses = statuses
+
+	return healthy
+}
+
+// Checks the repositories until every one is healthy, or the timeout or the given context is done.
+func (health *Health) WaitReady(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for !health.Check(ctx) {
+		select {
+		case <-time.After(HEALTH_WAIT_RETRY):
+		case <-ctx.Done():
+			var failed []string
+			for _, status := range health.Statuses() {
+				if !status.Healthy {
+					failed = append(failed, status.Name+": "+status.Error)
+				}
+			}
+
+			return errors.New(fmt.Sprintf("Repositories not ready after %s: %s", timeout, strings.Join(failed, "; ")))
+		}
+	}
+
+	return nil
+}
+
+// Checks the repositories right away and then on each interval, on its own goroutine, until the
+// given context is done.
+func (health *Health) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		health.Check(ctx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				health.Check(ctx)
+			}
+		}
+	}()
+}
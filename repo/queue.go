@@ -0,0 +1,557 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/stats"
+)
+
+const (
+	QUEUE_MIN_BACKOFF     = 500 * time.Millisecond // wait before the first retry of a failed push.
+	QUEUE_MAX_BACKOFF     = 30 * time.Second       // limit of the wait between retries.
+	QUEUE_REPORT_INTERVAL = time.Minute            // minimum time between reports of lost data.
+)
+
+// Options of a push queue.
+type QueueOptions struct {
+	Size       int           // maximum number of pending pushes.
+	Batch      int           // number of pushes done at once.
+	Flush      time.Duration // maximum time a push waits for its batch to fill.
+	Retries    int           // number of times a failed push is retried before discarding it.
+	DropOldest bool          // whether the oldest pending stats are dropped when full, instead of the new entry.
+
+	// receives the stats of the pushes discarded after failing every retry, instead of losing them,
+	// nil to just discard them.
+	DeadLetter *DeadLetter
+
+	// keeps the stats of the pushes that failed every retry on disk, to replay them in order once the
+	// repository recovers, nil to not spool them. While it has stats to replay, new stats are spooled
+	// behind them.
+	Spool *Spool
+}
+
+// Options used when the queue is created by Create.
+var DefaultQueueOptions = QueueOptions{
+	Size:       10000,
+	Batch:      100,
+	Flush:      time.Second,
+	Retries:    3,
+	DropOldest: true,
+}
+
+// Counters of a push queue, to know how much data was lost.
+type QueueStats struct {
+	Pushed   uint64 // pushes done.
+	Retried  uint64 // retries of failed pushes.
+	Dropped  uint64 // stats, events or listings dropped because the queue was full.
+	Failed   uint64 // pushes discarded after failing every retry.
+	Spooled  uint64 // pushes kept on the spool.
+	Replayed uint64 // pushes replayed from the spool.
+}
+
+// Pending operation of the queue, stats is only set for pushes of stats.
+type entry struct {
+	stats *stats.Stats
+	op    operation
+	group uint64 // shared by the stats of a single batch push, not to split them across flushes, 0 if none.
+}
+
+// Repository which queues the pushes to another repository, to be done in batches by its own
+// goroutine, so a slow repository doesn't stall the Docker queries. Failed pushes are retried with
+// exponential backoff. The stats of a batch are pushed at once if the repository is capable of it.
+type Queue struct {
+	repo    Interface
+	options QueueOptions
+
+	lock    sync.Mutex // guards pending, closed and groups.
+	pending []entry
+	closed  bool
+	groups  uint64 // last group given to a batch push.
+
+	ready chan struct{} // signaled once there's a full batch.
+	quit  chan struct{} // closed once closing, to flush everything left.
+	abort chan struct{} // closed once the close deadline is reached, to stop retrying.
+	done  chan struct{} // closed once the worker finishes.
+
+	stats      QueueStats // updated atomically.
+	reported   QueueStats // counters at the last report of lost data, only used by the worker.
+	reportedAt time.Time  // time of the last report of lost data, only used by the worker.
+}
+
+// Creates a queue in front of the given repository, starting its worker.
+func NewQueue(r Interface, options QueueOptions) *Queue {
+	q := &Queue{
+		repo:    r,
+		options: options,
+		ready:   make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		abort:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go q.work()
+
+	return q
+}
+
+// Creates a queue, v must be the repository behind it.
+func (*Queue) Create(v interface{}) (Interface, error) {
+	r, ok := v.(Interface)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Queue needs a repository, got %T", v))
+	}
+
+	return NewQueue(r, DefaultQueueOptions), nil
+}
+
+// Name of the repository behind the queue.
+func (q *Queue) Name() string {
+	return q.repo.Name()
+}
+
+// Current counters of the queue.
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{
+		Pushed:   atomic.LoadUint64(&q.stats.Pushed),
+		Retried:  atomic.LoadUint64(&q.stats.Retried),
+		Dropped:  atomic.LoadUint64(&q.stats.Dropped),
+		Failed:   atomic.LoadUint64(&q.stats.Failed),
+		Spooled:  atomic.LoadUint64(&q.stats.Spooled),
+		Replayed: atomic.LoadUint64(&q.stats.Replayed),
+	}
+}
+
+func (q *Queue) Push(s *stats.Stats) error {
+	q.add(entry{stats: s, op: func(r Interface) error {
+		return r.Push(s)
+	}}, false)
+
+	return nil
+}
+
+// Queues the stats of a batch together, so they are pushed at once if the repository is capable of it.
+func (q *Queue) PushBatch(batch []*stats.Stats) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.groups++
+
+	for _, s := range batch {
+		s := s
+		q.insert(entry{stats: s, group: q.groups, op: func(r Interface) error {
+			return r.Push(s)
+		}}, false)
+	}
+
+	return nil
+}
+
+// Queues the event, only if the repository behind is capable of storing events.
+func (q *Queue) PushEvent(e *stats.Event) error {
+	if _, ok := q.repo.(EventInterface); !ok {
+		return nil
+	}
+
+	q.add(entry{op: func(r Interface) error {
+		return r.(EventInterface).PushEvent(e)
+	}}, false)
+
+	return nil
+}
+
+// Queues the process listing, only if the repository behind is capable of storing them.
+func (q *Queue) PushProcesses(p *stats.Processes) error {
+	if _, ok := q.repo.(ProcessInterface); !ok {
+		return nil
+	}
+
+	q.add(entry{op: func(r Interface) error {
+		return r.(ProcessInterface).PushProcesses(p)
+	}}, false)
+
+	return nil
+}
+
+// Checks the repository behind is reachable, if it's capable of it, right away instead of after the
+// pending pushes.
+func (q *Queue) Ping(ctx context.Context) error {
+	return Ping(ctx, q.repo)
+}
+
+// Queues the clear of the container, after its pending pushes. It's never dropped, otherwise the
+// repository could keep data of a container that is gone.
+func (q *Queue) Clear(host string, name string) {
+	q.add(entry{op: func(r Interface) error {
+		r.Clear(host, name)
+		return nil
+	}}, true)
+}
+
+// Flushes the pending pushes and closes the repository behind, until the given context is done.
+// Failed pushes are not retried after that.
+func (q *Queue) Close(ctx context.Context) error {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.quit)
+	}
+	q.lock.Unlock()
+
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		// stops retrying, the worker may still be doing a push, so the repository, the spool and the dead
+		// letter file are closed once it's done.
+		close(q.abort)
+
+		go func() {
+			<-q.done
+			q.release(context.Background())
+		}()
+
+		return ctx.Err()
+	}
+
+	return q.release(ctx)
+}
+
+// Reports the counters and closes the spool, the dead letter file and the repository behind, once the
+// worker is done.
+func (q *Queue) release(ctx context.Context) error {
+	s := q.Stats()
+	log.Info.Printf("Repository %s queue: %d pushed, %d retried, %d dropped, %d failed, %d spooled, %d replayed.",
+		q.repo.Name(), s.Pushed, s.Retried, s.Dropped, s.Failed, s.Spooled, s.Replayed)
+
+	if q.options.Spool != nil {
+		if err := q.options.Spool.Close(); err != nil {
+			log.Error.Printf("Repository %s: cannot close the spool: %s", q.repo.Name(), err.Error())
+		}
+	}
+
+	if q.options.DeadLetter != nil {
+		if err := q.options.DeadLetter.Close(); err != nil {
+			log.Error.Printf("Repository %s: cannot close the dead letter file: %s", q.repo.Name(), err.Error())
+		}
+	}
+
+	return q.repo.Close(ctx)
+}
+
+// Adds an entry to the queue, applying the drop policy if full, unless forced.
+func (q *Queue) add(e entry, force bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.insert(e, force)
+}
+
+// Adds an entry to the queue, with the lock held.
+func (q *Queue) insert(e entry, force bool) {
+	if q.closed {
+		return
+	}
+
+	if !force && len(q.pending) >= q.options.Size {
+		atomic.AddUint64(&q.stats.Dropped, 1)
+
+		// only stats are evicted, a clear, an event or a listing is never dropped once queued.
+		oldest := -1
+		if q.options.DropOldest {
+			for i := range q.pending {
+				if q.pending[i].stats != nil {
+					oldest = i
+					break
+				}
+			}
+		}
+
+		if oldest < 0 {
+			return
+		}
+
+		q.pending = append(q.pending[:oldest], q.pending[oldest+1:]...)
+	}
+
+	q.pending = append(q.pending, e)
+
+	if len(q.pending) >= q.options.Batch {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Takes the next batch of pending entries.
+func (q *Queue) take() []entry {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	n := q.options.Batch
+	if n > len(q.pending) {
+		n = len(q.pending)
+	}
+
+	// the rest of a batch push goes along, so it's done on a single push.
+	for n > 0 && n < len(q.pending) && q.pending[n].group != 0 && q.pending[n].group == q.pending[n-1].group {
+		n++
+	}
+
+	batch := make([]entry, n)
+	copy(batch, q.pending)
+	q.pending = q.pending[n:]
+
+	return batch
+}
+
+// Flushes the pending entries on each flush interval or once there's a full batch, until closed.
+func (q *Queue) work() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.options.Flush)
+	defer ticker.Stop()
+
+	for {
+		closing := false
+
+		select {
+		case <-q.ready:
+		case <-ticker.C:
+		case <-q.quit:
+			closing = true
+		}
+
+		// the spool is left for the next run when closing.
+		if !closing {
+			q.replay()
+		}
+
+		for batch := q.take(); len(batch) > 0; batch = q.take() {
+			q.flush(batch)
+		}
+
+		q.report()
+
+		if closing {
+			return
+		}
+	}
+}
+
+// Does every entry of the batch, in order. If the repository is capable of pushing many stats at
+// once, consecutive pushes of stats are grouped on a single push.
+func (q *Queue) flush(batch []entry) {
+	if _, ok := q.repo.(BatchInterface); !ok {
+		for _, e := range batch {
+			q.do(e)
+		}
+		return
+	}
+
+	var group []entry
+
+	for _, e := range batch {
+		if e.stats != nil {
+			group = append(group, e)
+			continue
+		}
+
+		q.doGroup(group)
+		group = nil
+
+		q.do(e)
+	}
+
+	q.doGroup(group)
+}
+
+// Does the grouped pushes of stats, on a single push unless there's only one.
+func (q *Queue) doGroup(group []entry) {
+	switch len(group) {
+	case 0:
+	case 1:
+		q.do(group[0])
+	default:
+		q.doBatch(group)
+	}
+}
+
+// Does a single entry, retrying with exponential backoff if it fails.
+func (q *Queue) do(e entry) {
+	// keeps the order of the stats spooled before.
+	if e.stats != nil && q.options.Spool != nil && !q.options.Spool.Empty() {
+		q.spool(e)
+		return
+	}
+
+	if err := q.retry(e.op); err != nil {
+		q.discard(e)
+		return
+	}
+
+	if e.stats != nil {
+		atomic.AddUint64(&q.stats.Pushed, 1)
+	}
+}
+
+// Pushes the stats of the entries on a single push, retrying with exponential backoff if it fails.
+func (q *Queue) doBatch(group []entry) {
+	if q.options.Spool != nil && !q.options.Spool.Empty() {
+		for _, e := range group {
+			q.spool(e)
+		}
+		return
+	}
+
+	batch := make([]*stats.Stats, len(group))
+	for i, e := range group {
+		batch[i] = e.stats
+	}
+
+	err := q.retry(func(r Interface) error {
+		return r.(BatchInterface).PushBatch(batch)
+	})
+
+	if err != nil {
+		for _, e := range group {
+			q.discard(e)
+		}
+		return
+	}
+
+	atomic.AddUint64(&q.stats.Pushed, uint64(len(group)))
+}
+
+// Does the operation, retrying with exponential backoff while it fails. Returns the last error once
+// every retry failed or the close deadline is reached.
+func (q *Queue) retry(op operation) error {
+	backoff := QUEUE_MIN_BACKOFF
+
+	for attempt := 0; ; attempt++ {
+		err := q.try(op)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= q.options.Retries {
+			log.Error.Printf("Repository %s: giving up after %d retries: %s", q.repo.Name(), attempt, err.Error())
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-q.abort:
+			return err
+		}
+
+		atomic.AddUint64(&q.stats.Retried, 1)
+
+		backoff *= 2
+		if backoff > QUEUE_MAX_BACKOFF {
+			backoff = QUEUE_MAX_BACKOFF
+		}
+	}
+}
+
+// Counts a failed entry, handing its stats to the spool or the dead letter handler, if any.
+func (q *Queue) discard(e entry) {
+	if e.stats != nil && q.options.Spool != nil {
+		q.spool(e)
+		return
+	}
+
+	atomic.AddUint64(&q.stats.Failed, 1)
+
+	if e.stats != nil && q.options.DeadLetter != nil {
+		q.options.DeadLetter.Write(e.stats)
+	}
+}
+
+// Appends the stats of the entry to the spool, it's discarded if the spool fails.
+func (q *Queue) spool(e entry) {
+	if err := q.options.Spool.Append(e.stats); err != nil {
+		log.Error.Printf("Repository %s: cannot spool stats: %s", q.repo.Name(), err.Error())
+		atomic.AddUint64(&q.stats.Failed, 1)
+
+		if q.options.DeadLetter != nil {
+			q.options.DeadLetter.Write(e.stats)
+		}
+		return
+	}
+
+	atomic.AddUint64(&q.stats.Spooled, 1)
+}
+
+// Replays the spooled stats, in order, until one fails, which is retried on the next flush.
+func (q *Queue) replay() {
+	spool := q.options.Spool
+	if spool == nil || spool.Empty() {
+		return
+	}
+
+	// replayed in batches if the repository is capable of it, not to do a push for each one.
+	size := 1
+	push := func(batch []*stats.Stats) error {
+		return q.try(func(r Interface) error {
+			return r.Push(batch[0])
+		})
+	}
+
+	if _, ok := q.repo.(BatchInterface); ok {
+		size = q.options.Batch
+		push = func(batch []*stats.Stats) error {
+			return q.try(func(r Interface) error {
+				return r.(BatchInterface).PushBatch(batch)
+			})
+		}
+	}
+
+	n, err := spool.Replay(size, push)
+
+	atomic.AddUint64(&q.stats.Replayed, uint64(n))
+
+	if err != nil {
+		if n > 0 {
+			log.Warning.Printf("Repository %s: replay stopped after %d spooled stats: %s", q.repo.Name(), n, err.Error())
+		}
+		return
+	}
+
+	log.Info.Printf("Repository %s: replayed %d spooled stats.", q.repo.Name(), n)
+}
+
+// Does the operation, a panic is reported as an error.
+func (q *Queue) try(op operation) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("Panic: %v", r))
+		}
+	}()
+
+	return op(q.repo)
+}
+
+// Logs a warning if data was lost since the last report, at most once per report interval.
+func (q *Queue) report() {
+	s := q.Stats()
+
+	if s.Dropped == q.reported.Dropped && s.Failed == q.reported.Failed {
+		return
+	}
+
+	if time.Since(q.reportedAt) < QUEUE_REPORT_INTERVAL {
+		return
+	}
+
+	log.Warning.Printf("Repository %s lost data: %d dropped (queue full) and %d failed since last report.",
+		q.repo.Name(), s.Dropped-q.reported.Dropped, s.Failed-q.reported.Failed)
+
+	q.reported = s
+	q.reportedAt = time.Now()
+}
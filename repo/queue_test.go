@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/stats"
+)
+
+// Repository stand-in capable of batch pushes, recording the size of each push.
+type batcher struct {
+	flaky
+
+	lock  sync.Mutex
+	sizes []int
+}
+
+func (b *batcher) PushBatch(batch []*stats.Stats) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sizes = append(b.sizes, len(batch))
+	return nil
+}
+
+func TestQueuePushBatch(t *testing.T) {
+	repo := &batcher{}
+
+	options := DefaultQueueOptions
+	options.Batch = 2
+	options.Flush = time.Hour
+
+	q := NewQueue(repo, options)
+
+	var batch []*stats.Stats
+	for i := 0; i < 3; i++ {
+		batch = append(batch, &stats.Stats{Name: fmt.Sprint(i)})
+	}
+
+	// a batch over the queue batch size is not split.
+	q.PushBatch(batch)
+	q.Push(&stats.Stats{Name: "3"})
+
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{3}; !reflect.DeepEqual(repo.sizes, want) {
+		t.Errorf("batch sizes %v, want %v", repo.sizes, want)
+	}
+}
@@ -1,6 +1,8 @@
 package repo
 
 import (
+	"context"
+
 	"github.com/mijara/statspout/stats"
 )
 
@@ -16,12 +18,62 @@ type Interface interface {
 	// The repository should return an error if it's not capable of pushing the stats.
 	Push(stats *stats.Stats) error
 
-	// Close the service.
-	Close()
+	// Close the service, flushing any pending data and stopping its servers, until the given context is done.
+	// The repository should return an error if it could not close in time.
+	Close(ctx context.Context) error
 
-	// Clears data of the named container from this repository (useful for repositories that store container data).
-	Clear(name string)
+	// Clears data of the named container of the given host from this repository (useful for repositories that
+	// store container data).
+	Clear(host string, name string)
 
 	// Canonical name of this repository, used to identify it in the command line flags.
 	Name() string
 }
+
+// Optional capability of a repository, which can store container lifecycle events (started, stopped,
+// OOM-killed, etc.) alongside the stats, for instance, as annotations.
+type EventInterface interface {
+	// Push a container event to this service.
+	// The repository should return an error if it's not capable of pushing the event.
+	PushEvent(event *stats.Event) error
+}
+
+// Optional capability of a repository, which can store the listing of the top processes of each container
+// as documents.
+type ProcessInterface interface {
+	// Push the process listing of a container to this service.
+	// The repository should return an error if it's not capable of pushing the listing.
+	PushProcesses(processes *stats.Processes) error
+}
+
+// Optional capability of a repository, which can store the stats of many containers at once, for instance,
+// on a single bulk write.
+type BatchInterface interface {
+	// Push the stats of many containers to this service, in order.
+	// The repository should return an error if it's not capable of pushing the stats, none of them is
+	// considered pushed in that case.
+	PushBatch(batch []*stats.Stats) error
+}
+
+// Optional capability of a repository, which can tell whether the service behind it is reachable.
+type HealthInterface interface {
+	// Checks the service is reachable and ready to have data pushed to it, until the given context is done.
+	// The repository should return an error if it's not.
+	Ping(ctx context.Context) error
+}
+
+// Pushes the stats of many containers at once if the repository is capable of it, otherwise one at a time,
+// stopping on the first error.
+func PushBatch(r Interface, batch []*stats.Stats) error {
+	if br, ok := r.(BatchInterface); ok {
+		return br.PushBatch(batch)
+	}
+
+	for _, s := range batch {
+		if err := r.Push(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package repo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijara/statspout/log"
+	"github.com/mijara/statspout/stats"
+)
+
+const (
+	SPOOL_SEGMENT_SIZE = 4 * 1024 * 1024 // size of a segment file before starting a new one.
+	SPOOL_MIN_SEGMENTS = 4               // segments a size cap under 4 segment sizes is split into.
+	SPOOL_SEGMENT_EXT  = ".spool"
+)
+
+// Segment file of the spool, named after its sequence number.
+type segment struct {
+	seq      uint64
+	size     int64
+	modified time.Time
+}
+
+// Write-ahead spool of stats that could not be pushed, kept on disk until they can be replayed in
+// the same order, even after a restart. Stats are appended as JSON lines to segment files, the
+// oldest segments are removed once the spool is over its size or age caps. Segments are smaller
+// than the size cap, so the spool never goes over it. Replay is at least once: after a restart, a
+// segment partially replayed is replayed from its beginning.
+type Spool struct {
+	dir         string
+	maxSize     int64         // maximum size of all the segments, in bytes.
+	maxAge      time.Duration // maximum age of a segment, since its last write.
+	segmentSize int64         // size of a segment file before starting a new one.
+
+	lock     sync.Mutex
+	segments []segment // oldest first.
+	writer   *os.File  // last segment, open for appending, nil if there's none open.
+	replayed int       // lines of the first segment already replayed.
+}
+
+// Opens the spool on the given directory, creating it if needed. Segments left by a previous run are
+// replayed before anything appended from now on.
+func OpenSpool(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &Spool{
+		dir:         dir,
+		maxSize:     maxSize,
+		maxAge:      maxAge,
+		segmentSize: SPOOL_SEGMENT_SIZE,
+	}
+
+	// the segment open for appending is never removed, so it must fit the size cap by itself.
+	if maxSize > 0 && maxSize < SPOOL_SEGMENT_SIZE*SPOOL_MIN_SEGMENTS {
+		spool.segmentSize = maxSize / SPOOL_MIN_SEGMENTS
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), SPOOL_SEGMENT_EXT) {
+			continue
+		}
+
+		var seq uint64
+		if _, err := fmt.Sscanf(f.Name(), "%d"+SPOOL_SEGMENT_EXT, &seq); err != nil {
+			continue
+		}
+
+		spool.segments = append(spool.segments, segment{seq: seq, size: f.Size(), modified: f.ModTime()})
+	}
+
+	sort.Slice(spool.segments, func(i, j int) bool {
+		return spool.segments[i].seq < spool.segments[j].seq
+	})
+
+	spool.lock.Lock()
+	spool.enforce()
+	spool.lock.Unlock()
+
+	if len(spool.segments) > 0 {
+		log.Info.Printf("Spool %s has %d segments to replay.", dir, len(spool.segments))
+	}
+
+	return spool, nil
+}
+
+// Whether there's nothing to replay.
+func (spool *Spool) Empty() bool {
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	return len(spool.segments) == 0
+}
+
+// Appends the stats at the end of the spool.
+func (spool *Spool) Append(s *stats.Stats) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	last := len(spool.segments) - 1
+
+	if spool.writer == nil || spool.segments[last].size+int64(len(line)) > spool.segmentSize {
+		if err := spool.rotate(); err != nil {
+			return err
+		}
+		last = len(spool.segments) - 1
+	}
+
+	n, err := spool.writer.Write(line)
+	spool.segments[last].size += int64(n)
+	spool.segments[last].modified = time.Now()
+	if err != nil {
+		return err
+	}
+
+	spool.enforce()
+
+	return nil
+}
+
+// Pushes the spooled stats in order, up to size stats on each push, removing each segment once fully
+// pushed. It stops on the first error, to continue from there on the next replay. Returns the number of
+// stats pushed.
+func (spool *Spool) Replay(size int, push func(batch []*stats.Stats) error) (int, error) {
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	pushed := 0
+
+	for len(spool.segments) > 0 {
+		head := spool.segments[0]
+
+		// stats appended from now on go to a new segment.
+		if len(spool.segments) == 1 && spool.writer != nil {
+			spool.writer.Close()
+			spool.writer = nil
+		}
+
+		n, err := spool.replaySegment(head, size, push)
+		pushed += n
+		if err != nil {
+			return pushed, err
+		}
+
+		if err := os.Remove(spool.path(head.seq)); err != nil && !os.IsNotExist(err) {
+			return pushed, err
+		}
+
+		spool.segments = spool.segments[1:]
+		spool.replayed = 0
+	}
+
+	return pushed, nil
+}
+
+// Closes the segment open for appending, the spool is kept on disk.
+func (spool *Spool) Close() error {
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	if spool.writer == nil {
+		return nil
+	}
+
+	err := spool.writer.Close()
+	spool.writer = nil
+
+	return err
+}
+
+// Pushes the lines of a segment not replayed yet, up to size on each push.
+func (spool *Spool) replaySegment(seg segment, size int, push func(batch []*stats.Stats) error) (int, error) {
+	f, err := os.Open(spool.path(seg.seq))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	pushed := 0
+
+	var batch []*stats.Stats
+	lines := 0 // lines read for the batch, corrupt ones included.
+
+	// pushes the batch, moving past its lines once pushed.
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := push(batch); err != nil {
+				return err
+			}
+		}
+
+		spool.replayed += lines
+		pushed += len(batch)
+
+		batch = nil
+		lines = 0
+
+		return nil
+	}
+
+	for line := 0; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			// end of the segment.
+			err := flush()
+			return pushed, err
+		}
+
+		if line < spool.replayed {
+			continue
+		}
+
+		lines++
+
+		s := &stats.Stats{}
+		if err := json.Unmarshal(data, s); err != nil {
+			// a line cut by a crash, nothing to do with it.
+			log.Error.Printf("Spool %s: skipping corrupt line of segment %d: %s", spool.dir, seg.seq, err.Error())
+			continue
+		}
+
+		batch = append(batch, s)
+
+		if len(batch) >= size {
+			if err := flush(); err != nil {
+				return pushed, err
+			}
+		}
+	}
+}
+
+// Starts a new segment for appending.
+func (spool *Spool) rotate() error {
+	if spool.writer != nil {
+		spool.writer.Close()
+		spool.writer = nil
+	}
+
+	var seq uint64 = 1
+	if len(spool.segments) > 0 {
+		seq = spool.segments[len(spool.segments)-1].seq + 1
+	}
+
+	f, err := os.OpenFile(spool.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	spool.writer = f
+	spool.segments = append(spool.segments, segment{seq: seq, modified: time.Now()})
+
+	return nil
+}
+
+// Removes the oldest segments while the spool is over its size cap, or they are over the age cap.
+// The segment open for appending is never removed.
+func (spool *Spool) enforce() {
+	var total int64
+	for _, seg := range spool.segments {
+		total += seg.size
+	}
+
+	for len(spool.segments) > 1 || (len(spool.segments) == 1 && spool.writer == nil) {
+		head := spool.segments[0]
+
+		expired := spool.maxAge > 0 && time.Since(head.modified) > spool.maxAge
+		oversized := spool.maxSize > 0 && total > spool.maxSize
+
+		if !expired && !oversized {
+			return
+		}
+
+		if err := os.Remove(spool.path(head.seq)); err != nil && !os.IsNotExist(err) {
+			log.Error.Printf("Spool %s: cannot remove segment %d: %s", spool.dir, head.seq, err.Error())
+			return
+		}
+
+		log.Warning.Printf("Spool %s: discarded segment %d (%d bytes) over the size or age caps.",
+			spool.dir, head.seq, head.size)
+
+		total -= head.size
+		spool.segments = spool.segments[1:]
+		spool.replayed = 0
+	}
+}
+
+// Path of the segment file with the given sequence number.
+func (spool *Spool) path(seq uint64) string {
+	return filepath.Join(spool.dir, fmt.Sprintf("%020d%s", seq, SPOOL_SEGMENT_EXT))
+}
@@ -0,0 +1,299 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mijara/statspout/stats"
+)
+
+// Repository stand-in which fails every push while down, recording the names of the stats pushed.
+type flaky struct {
+	lock   sync.Mutex
+	down   bool
+	pushed []string
+}
+
+func (*flaky) Create(v interface{}) (Interface, error) {
+	return &flaky{}, nil
+}
+
+func (f *flaky) Push(s *stats.Stats) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.down {
+		return errors.New("Repository down")
+	}
+
+	f.pushed = append(f.pushed, s.Name)
+	return nil
+}
+
+func (*flaky) Close(ctx context.Context) error {
+	return nil
+}
+
+func (*flaky) Clear(host string, name string) {
+}
+
+func (*flaky) Name() string {
+	return "flaky"
+}
+
+func (f *flaky) setDown(down bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.down = down
+}
+
+func (f *flaky) names() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]string(nil), f.pushed...)
+}
+
+// Creates a temporary directory for the spool, removed once the test finishes.
+func tempSpoolDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	return dir
+}
+
+// Pushes the stats of each batch one at a time, with the given push.
+func pushEach(push func(s *stats.Stats) error) func(batch []*stats.Stats) error {
+	return func(batch []*stats.Stats) error {
+		for _, s := range batch {
+			if err := push(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Appends stats named from 0 to n-1.
+func appendStats(t *testing.T, spool *Spool, n int) {
+	for i := 0; i < n; i++ {
+		if err := spool.Append(&stats.Stats{Name: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	spool, err := OpenSpool(tempSpoolDir(t), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	appendStats(t, spool, 5)
+
+	repo := &flaky{}
+
+	// fails after the first two.
+	n, err := spool.Replay(1, pushEach(func(s *stats.Stats) error {
+		if len(repo.names()) == 2 {
+			repo.setDown(true)
+		}
+		return repo.Push(s)
+	}))
+	if err == nil || n != 2 {
+		t.Fatalf("Replay() = %d, %v, want 2 and an error", n, err)
+	}
+
+	// appended behind the stats left.
+	appendStats(t, spool, 1)
+
+	repo.setDown(false)
+	n, err = spool.Replay(1, pushEach(repo.Push))
+	if err != nil || n != 4 {
+		t.Fatalf("Replay() = %d, %v, want 4 and no error", n, err)
+	}
+
+	want := []string{"0", "1", "2", "3", "4", "0"}
+	if got := repo.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("pushed %v, want %v", got, want)
+	}
+
+	if !spool.Empty() {
+		t.Error("spool not empty after replaying everything")
+	}
+}
+
+func TestSpoolReplayBatch(t *testing.T) {
+	spool, err := OpenSpool(tempSpoolDir(t), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	appendStats(t, spool, 5)
+
+	var sizes []int
+	n, err := spool.Replay(2, func(batch []*stats.Stats) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	})
+	if err != nil || n != 5 {
+		t.Fatalf("Replay() = %d, %v, want 5 and no error", n, err)
+	}
+
+	if want := []int{2, 2, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("batch sizes %v, want %v", sizes, want)
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := tempSpoolDir(t)
+
+	spool, err := OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendStats(t, spool, 3)
+	spool.Close()
+
+	spool, err = OpenSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	repo := &flaky{}
+	if _, err := spool.Replay(1, pushEach(repo.Push)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"0", "1", "2"}
+	if got := repo.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("pushed %v, want %v", got, want)
+	}
+}
+
+func TestSpoolCaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		maxAge  time.Duration
+	}{
+		{"size", 1, 0},
+		{"age", 0, time.Nanosecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempSpoolDir(t)
+
+			spool, err := OpenSpool(dir, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			appendStats(t, spool, 3)
+			spool.Close()
+
+			spool, err = OpenSpool(dir, tt.maxSize, tt.maxAge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer spool.Close()
+
+			if !spool.Empty() {
+				t.Error("spool not empty over its caps")
+			}
+		})
+	}
+}
+
+func TestQueueSpool(t *testing.T) {
+	spool, err := OpenSpool(tempSpoolDir(t), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &flaky{down: true}
+
+	options := DefaultQueueOptions
+	options.Flush = 10 * time.Millisecond
+	options.Retries = 0
+	options.Spool = spool
+
+	q := NewQueue(repo, options)
+
+	for i := 0; i < 3; i++ {
+		q.Push(&stats.Stats{Name: fmt.Sprint(i)})
+	}
+
+	// waits for the pushes to fail and be spooled.
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Stats().Spooled < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	repo.setDown(false)
+	q.Push(&stats.Stats{Name: "3"})
+
+	for len(repo.names()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"0", "1", "2", "3"}
+	if got := repo.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("pushed %v, want %v", got, want)
+	}
+
+	if s := q.Stats(); s.Spooled != 3 || s.Replayed != 3 || s.Failed != 0 {
+		t.Errorf("Stats() = %+v, want 3 spooled, 3 replayed and none failed", s)
+	}
+}
+
+func TestSpoolSizeCap(t *testing.T) {
+	const maxSize = 64 * 1024
+
+	dir := tempSpoolDir(t)
+
+	spool, err := OpenSpool(dir, maxSize, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	appendStats(t, spool, 1000)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+
+	if total == 0 || total > maxSize {
+		t.Errorf("spool of %d bytes, want some and at most %d", total, maxSize)
+	}
+}
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+)
+
+// Container lifecycle actions.
+const (
+	EVENT_STARTED        = "started"
+	EVENT_STOPPED        = "stopped"
+	EVENT_DIED           = "died"
+	EVENT_OOM_KILLED     = "oom_killed"
+	EVENT_RESTARTED      = "restarted"
+	EVENT_RENAMED        = "renamed"
+	EVENT_HEALTH_CHANGED = "health_changed"
+)
+
+// Standard project container lifecycle event, to annotate the stats of a container.
+type Event struct {
+	// Timestamp of this event.
+	Timestamp time.Time `json:"@timestamp"`
+
+	// associated container of this event.
+	Name string `json:"name"`
+
+	// identifier of the Docker host running the container.
+	Host string `json:"host"`
+
+	// what happened to the container, one of the EVENT_* actions.
+	Action string `json:"action"`
+
+	// previous name of the container, only for renamed.
+	OldName string `json:"old_name,omitempty"`
+
+	// new health status of the container, only for health_changed.
+	Health string `json:"health,omitempty"`
+
+	// exit code of the container, only for died.
+	ExitCode string `json:"exit_code,omitempty"`
+}
+
+// Prints the event in a nice format.
+func (event *Event) String() string {
+	return fmt.Sprintf("[%s@%s] {%s} %s", event.Name, event.Host,
+		event.Timestamp.Format("02 Jan 06 15:04:05 MST"), event.Description())
+}
+
+// Human readable description of the event.
+func (event *Event) Description() string {
+	switch event.Action {
+	case EVENT_STARTED:
+		return "Container started"
+	case EVENT_STOPPED:
+		return "Container stopped"
+	case EVENT_DIED:
+		return "Container died with exit code " + event.ExitCode
+	case EVENT_OOM_KILLED:
+		return "Container ran out of memory"
+	case EVENT_RESTARTED:
+		return "Container restarted"
+	case EVENT_RENAMED:
+		return "Container renamed from " + event.OldName
+	case EVENT_HEALTH_CHANGED:
+		return "Container is " + event.Health
+	}
+
+	return "Container " + event.Action
+}
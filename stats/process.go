@@ -0,0 +1,30 @@
+package stats
+
+import (
+	"time"
+)
+
+// Standard project listing of the top processes of a container, by CPU usage.
+type Processes struct {
+	// Timestamp of this listing.
+	Timestamp time.Time `json:"@timestamp"`
+
+	// associated container of this listing.
+	Name string `json:"name"`
+
+	// identifier of the Docker host running the container.
+	Host string `json:"host"`
+
+	// processes, sorted by CPU usage, highest first.
+	Processes []Process `json:"processes"`
+}
+
+// Single process running in a container, as reported by ps.
+type Process struct {
+	Pid           int     `json:"pid"`
+	User          string  `json:"user"`
+	CpuPercent    float64 `json:"cpu_percent"`
+	MemoryPercent float64 `json:"mem_percent"`
+	Rss           uint64  `json:"rss"` // resident memory, in bytes.
+	Command       string  `json:"command"`
+}
@@ -13,26 +13,187 @@ type Stats struct {
 	// associated container of this stats.
 	Name string `json:"name"`
 
+	// identifier of the Docker host running the container.
+	Host string `json:"host"`
+
+	// whether the container is paused, so its CPU usage is not meaningful.
+	Paused bool `json:"paused"`
+
 	// CPU usage percent.
 	CpuPercent float64 `json:"cpu_percent"`
 
-	// Memory usage in bytes.
+	// CPU usage percent spent in user space and in the kernel.
+	CpuUserPercent   float64 `json:"cpu_user_percent"`
+	CpuSystemPercent float64 `json:"cpu_system_percent"`
+
+	// Ratio (0 to 1) of CFS periods in which the container was throttled, since the previous read,
+	// and total time throttled, in nanoseconds. Only reported for containers with a CPU limit.
+	CpuThrottledRatio float64 `json:"cpu_throttled_ratio"`
+	CpuThrottledTime  uint64  `json:"cpu_throttled_time"`
+
+	// Memory usage in bytes, including the page cache.
 	MemoryUsage uint64 `json:"mem_usage"`
 
-	// Memory usage percent.
+	// Memory usage percent, of the working set over the limit.
 	MemoryPercent float64 `json:"mem_percent"`
 
+	// Memory breakdown in bytes. The working set is the usage without the inactive page cache,
+	// as shown by docker stats.
+	MemoryLimit      uint64 `json:"mem_limit"`
+	MemoryRss        uint64 `json:"mem_rss"`
+	MemoryCache      uint64 `json:"mem_cache"`
+	MemorySwap       uint64 `json:"mem_swap"`
+	MemoryWorkingSet uint64 `json:"mem_working_set"`
+
+	// Page faults, minor and major, since the container started.
+	MemoryPgFaults    uint64 `json:"mem_pgfaults"`
+	MemoryPgMajFaults uint64 `json:"mem_pgmajfaults"`
+
 	// Transmit and Receive network stats, in bytes.
-	TxBytesTotal uint32 `json:"tx_bytes"`
-	RxBytesTotal uint32 `json:"rx_bytes"`
+	TxBytesTotal uint64 `json:"tx_bytes"`
+	RxBytesTotal uint64 `json:"rx_bytes"`
+
+	// Number of processes and threads, and their limit (zero if unlimited).
+	Pids      uint64 `json:"pids"`
+	PidsLimit uint64 `json:"pids_limit"`
+
+	// Health status, empty if the container has no health check.
+	Health string `json:"health"`
+
+	// Number of restarts done by the restart policy, and whether the last exit was because of
+	// running out of memory.
+	RestartCount int  `json:"restart_count"`
+	OOMKilled    bool `json:"oom_killed"`
+
+	// Seconds since the container started.
+	Uptime float64 `json:"uptime"`
+
+	// Limits configured for the container, zero if unlimited: CPU in cores and memory in bytes. The
+	// memory limit above is the effective one, which is the host memory if unlimited.
+	CpuLimit              float64 `json:"cpu_limit"`
+	MemoryLimitConfigured uint64  `json:"mem_limit_configured"`
+
+	// Network rates since the previous sample, in bytes and packets per second.
+	RxBytesRate   float64 `json:"rx_bytes_rate"`
+	TxBytesRate   float64 `json:"tx_bytes_rate"`
+	RxPacketsRate float64 `json:"rx_packets_rate"`
+	TxPacketsRate float64 `json:"tx_packets_rate"`
+
+	// Network stats of each interface, in bytes and packets.
+	Networks []NetworkInterface `json:"networks"`
+
+	// Block I/O stats of every device, in bytes and operations.
+	BlockReadBytes  uint64 `json:"blk_read_bytes"`
+	BlockWriteBytes uint64 `json:"blk_write_bytes"`
+	BlockReadOps    uint64 `json:"blk_read_ops"`
+	BlockWriteOps   uint64 `json:"blk_write_ops"`
+
+	// Block I/O rates since the previous sample, in bytes per second.
+	BlockReadRate  float64 `json:"blk_read_rate"`
+	BlockWriteRate float64 `json:"blk_write_rate"`
+
+	// Block I/O stats by device, only if the breakdown is enabled.
+	BlockDevices []BlockDevice `json:"blk_devices,omitempty"`
 
 	Labels map[string]string
+
+	// identity metadata of the container, flattened.
+	Identity `bson:",inline"`
+}
+
+// Identity metadata of a container, to group stats by image, orchestrator service, etc. Orchestrator
+// fields are empty unless the container was created by them.
+type Identity struct {
+	ID      string    `json:"id"`
+	ShortID string    `json:"short_id"`
+	Image   string    `json:"image"`
+	ImageID string    `json:"image_id"`
+	Command string    `json:"command"`
+	Created time.Time `json:"created"`
+
+	// Docker Compose project and service.
+	ComposeProject string `json:"compose_project,omitempty"`
+	ComposeService string `json:"compose_service,omitempty"`
+
+	// Docker Swarm service and task.
+	SwarmService string `json:"swarm_service,omitempty"`
+	SwarmTask    string `json:"swarm_task,omitempty"`
+
+	// Kubernetes namespace, pod and container.
+	K8sNamespace string `json:"k8s_namespace,omitempty"`
+	K8sPod       string `json:"k8s_pod,omitempty"`
+	K8sContainer string `json:"k8s_container,omitempty"`
+}
+
+// Identity fields meant to be used as tags or labels, by name, only the ones set.
+func (identity *Identity) Tags() map[string]string {
+	tags := make(map[string]string)
+
+	add := func(name string, value string) {
+		if value != "" {
+			tags[name] = value
+		}
+	}
+
+	add("id", identity.ShortID)
+	add("image", identity.Image)
+	add("compose_project", identity.ComposeProject)
+	add("compose_service", identity.ComposeService)
+	add("swarm_service", identity.SwarmService)
+	add("swarm_task", identity.SwarmTask)
+	add("k8s_namespace", identity.K8sNamespace)
+	add("k8s_pod", identity.K8sPod)
+	add("k8s_container", identity.K8sContainer)
+
+	return tags
+}
+
+// Network stats of a single interface of the container.
+type NetworkInterface struct {
+	// name of the interface inside the container, such as eth0.
+	Interface string `json:"interface"`
+
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// Block I/O stats of a single device.
+type BlockDevice struct {
+	// device number, as major:minor.
+	Device string `json:"device"`
+
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	ReadOps    uint64 `json:"read_ops"`
+	WriteOps   uint64 `json:"write_ops"`
 }
 
 // Prints stats in a nice format.
 func (stats *Stats) String() string {
-	return fmt.Sprintf("[%s] {%s} CPU: %.2f%%, MEM: %.2f%% [%d B] Tx/Rx: %d/%d",
-		stats.Name, stats.Timestamp.Format("02 Jan 06 15:04:05 MST"),
-		stats.CpuPercent, stats.MemoryPercent, stats.MemoryUsage,
-		stats.TxBytesTotal, stats.RxBytesTotal)
+	// a paused container has no CPU usage to report.
+	cpu := "PAUSED"
+	if !stats.Paused {
+		cpu = fmt.Sprintf("CPU: %.2f%% (usr %.2f%%, sys %.2f%%, throttled %.2f%%)",
+			stats.CpuPercent, stats.CpuUserPercent, stats.CpuSystemPercent, stats.CpuThrottledRatio*100.0)
+	}
+
+	str := fmt.Sprintf("[%s@%s] {%s} %s, MEM: %.2f%% [%d/%d B] Tx/Rx: %d/%d (%.0f/%.0f B/s) Blk R/W: %d/%d (%.0f/%.0f B/s) Up: %s, Restarts: %d",
+		stats.Name, stats.Host, stats.Timestamp.Format("02 Jan 06 15:04:05 MST"), cpu,
+		stats.MemoryPercent, stats.MemoryWorkingSet, stats.MemoryLimit,
+		stats.TxBytesTotal, stats.RxBytesTotal, stats.TxBytesRate, stats.RxBytesRate,
+		stats.BlockReadBytes, stats.BlockWriteBytes, stats.BlockReadRate, stats.BlockWriteRate,
+		time.Duration(stats.Uptime)*time.Second, stats.RestartCount)
+
+	if stats.Health != "" {
+		str += " (" + stats.Health + ")"
+	}
+
+	return str
 }
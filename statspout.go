@@ -1,55 +1,137 @@
 package statspout
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/mijara/statspout/backend"
+	"github.com/mijara/statspout/common"
 	"github.com/mijara/statspout/log"
 	"github.com/mijara/statspout/opts"
+	"github.com/mijara/statspout/repo"
 )
 
-func loop(client *backend.Client, containers map[string]backend.Container) {
+// Queries every tracked container of the client that is not ignored, at once.
+func query(client *backend.Client) {
+	var containers []backend.Container
+	for _, container := range client.Registry().Snapshot() {
+		if !contains(opts.GetOpts().Ignore, container.CanonicalName) {
+			containers = append(containers, container)
+		}
+	}
+
+	client.Query(containers)
+}
+
+// Queries the processes of every tracked container of the client that is not ignored.
+func queryProcesses(client *backend.Client) {
+	for _, container := range client.Registry().Snapshot() {
+		if !contains(opts.GetOpts().Ignore, container.CanonicalName) {
+			client.QueryProcesses(container)
+		}
+	}
+}
+
+// Refreshes the inspect data of every tracked container of the client that is not ignored.
+func queryInspect(client *backend.Client) {
+	for _, container := range client.Registry().Snapshot() {
+		if !contains(opts.GetOpts().Ignore, container.CanonicalName) {
+			client.Inspect(container)
+		}
+	}
+}
+
+// Queries the clients on each interval until the context is done, their inspect data on each inspect
+// interval and their processes on each top interval, if enabled.
+func loop(ctx context.Context, clients []*backend.Client) {
 	ticker := time.NewTicker(time.Duration(opts.GetOpts().Interval) * time.Second)
 
-	closeC := make(chan os.Signal, 1)
-	signal.Notify(closeC, os.Interrupt, os.Kill)
+	// a nil channel never receives, so inspect data is never refreshed if disabled.
+	var inspectC <-chan time.Time
+	if opts.GetOpts().InspectInterval > 0 {
+		inspectTicker := time.NewTicker(time.Duration(opts.GetOpts().InspectInterval) * time.Second)
+		defer inspectTicker.Stop()
+
+		inspectC = inspectTicker.C
+	}
+
+	// same for the processes.
+	var topC <-chan time.Time
+	if opts.GetOpts().TopInterval > 0 {
+		topTicker := time.NewTicker(time.Duration(opts.GetOpts().TopInterval) * time.Second)
+		defer topTicker.Stop()
+
+		topC = topTicker.C
+	}
 
 	// initial loop.
-	for name := range containers {
-		if !contains(opts.GetOpts().Ignore, name) {
-			client.Query(containers[name])
+	for _, client := range clients {
+		if inspectC != nil {
+			queryInspect(client)
 		}
+		query(client)
 	}
 
 	for {
 		select {
-		case <-closeC:
-			log.Info.Printf("Stopping: closing Goroutines and Clients. Please wait...")
+		case <-ctx.Done():
 			ticker.Stop()
 			return
 		case <-ticker.C:
 			// query containers.
-			for name := range containers {
-				if !contains(opts.GetOpts().Ignore, name) {
-					client.Query(containers[name])
-				}
+			for _, client := range clients {
+				query(client)
+			}
+		case <-inspectC:
+			for _, client := range clients {
+				queryInspect(client)
+			}
+		case <-topC:
+			for _, client := range clients {
+				queryProcesses(client)
 			}
 		}
 	}
 }
 
-func inspect() {
+func inspect(ctx context.Context) {
 	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
 
-	for range ticker.C {
-		n := runtime.NumGoroutine()
-		log.Debug.Printf("Goroutines: %d", n)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n := runtime.NumGoroutine()
+			log.Debug.Printf("Goroutines: %d", n)
+		}
 	}
 }
 
+// Returns a context which is done once SIGINT or SIGTERM (sent by docker stop) is received.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	closeC := make(chan os.Signal, 1)
+	signal.Notify(closeC, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-closeC
+		log.Info.Printf("Received %s.", sig)
+		cancel()
+
+		// further signals just use the default behaviour, in case shutdown hangs.
+		signal.Stop(closeC)
+	}()
+
+	return ctx
+}
+
 func Start(cfg *opts.Config) {
 	opts.GetOpts().Parse()
 
@@ -57,44 +139,100 @@ func Start(cfg *opts.Config) {
 		log.Error.Fatal("Interval cannot be less than 1.")
 	}
 
+	if opts.GetOpts().TopInterval > 0 && opts.GetOpts().TopCount < 1 {
+		log.Error.Fatal("Top count cannot be less than 1.")
+	}
+
+	if opts.GetOpts().HealthTimeout < 0 || opts.GetOpts().HealthInterval < 0 {
+		log.Error.Fatal("Health timeout and interval cannot be less than 0.")
+	}
+
+	ctx := signalContext()
+
 	// start the Repo.
 	repository, err := opts.CreateRepositoryFromFlags(cfg)
 	if err != nil {
 		log.Error.Fatal(err)
 	}
-	defer repository.Close()
 
-	// start the Docker Endpoint.
-	client, err := opts.CreateClientFromFlags(repository)
-	if err != nil {
-		log.Error.Fatal(err)
+	health := repo.NewHealth(repository)
+
+	// wait for the repositories to be reachable, if asked to.
+	if opts.GetOpts().HealthTimeout > 0 {
+		log.Info.Printf("Waiting for the repositories to be ready...")
+
+		if err := health.WaitReady(ctx, time.Duration(opts.GetOpts().HealthTimeout)*time.Second); err != nil {
+			log.Error.Fatal(err)
+		}
+	}
+
+	if opts.GetOpts().HealthInterval > 0 {
+		health.Start(ctx, time.Duration(opts.GetOpts().HealthInterval)*time.Second)
+	}
+
+	var healthServer *common.HealthServer
+	if opts.GetOpts().Health.Address != "" {
+		healthServer, err = common.NewHealthServer(&opts.GetOpts().Health, health)
+		if err != nil {
+			log.Error.Fatal(err)
+		}
 	}
 
-	// get containers.
-	containers, err := client.GetContainers()
+	// start the Docker Endpoints.
+	clients, err := opts.CreateClientsFromFlags(ctx, repository)
 	if err != nil {
 		log.Error.Fatal(err)
 	}
 
+	for _, client := range clients {
+		// get containers.
+		if err := client.Sync(); err != nil {
+			log.Error.Fatal(err)
+		}
+	}
+
 	// small goroutine inspector.
-	go inspect()
+	go inspect(ctx)
 
-	log.Info.Printf("Statspout started: %d daemons, %d interval, %s mode, %s repo",
+	log.Info.Printf("Statspout started: %d hosts, %d daemons, %d interval, %s mode, %s collect, %s repo",
+		len(clients),
 		opts.GetOpts().Daemons,
 		opts.GetOpts().Interval,
 		opts.GetOpts().Mode.Name,
+		opts.GetOpts().Collect,
 		opts.GetOpts().Repository)
 
-	client.StartMonitor(containers)
+	for _, client := range clients {
+		client.StartMonitor()
+	}
 
 	// loop indefinitely until interrupt is received.
-	loop(client, containers)
+	loop(ctx, clients)
+
+	log.Info.Printf("Stopping: closing Goroutines and Clients. Please wait...")
+
+	// everything must be closed within the shutdown timeout.
+	shutdown, cancel := context.WithTimeout(context.Background(),
+		time.Duration(opts.GetOpts().ShutdownTimeout)*time.Second)
+	defer cancel()
 
-	// close all connections and goroutines.
-	client.Close()
+	// close all connections and goroutines, waiting for queries in progress.
+	for _, client := range clients {
+		if err := client.Close(shutdown); err != nil {
+			log.Error.Printf("Could not close client for %s: %s", client.Host(), err.Error())
+		}
+	}
+
+	// flush and close the repository once nothing else can be pushed.
+	if err := repository.Close(shutdown); err != nil {
+		log.Error.Printf("Could not close repository: %s", err.Error())
+	}
+
+	if healthServer != nil {
+		if err := healthServer.Close(shutdown); err != nil {
+			log.Error.Printf("Could not close health endpoint: %s", err.Error())
+		}
+	}
 
-	// force exit
-	// TODO: this is needed because EventsMonitor is not able to exit gracefully when reading the HTTP
-	// TODO: stream Docker API, I don't know how to fix it at this time.
-	os.Exit(0)
+	log.Info.Printf("Statspout stopped.")
 }